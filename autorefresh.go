@@ -0,0 +1,265 @@
+package glock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// autoRefresh is the state of the goroutine started by StartAutoRefresh
+type autoRefresh struct {
+	quit chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newAutoRefresh() *autoRefresh {
+	return &autoRefresh{quit: make(chan struct{}), done: make(chan struct{})}
+}
+
+// run calls refresh every interval on clock until stop is called or the lock
+// is lost, sending the errors on ch and closing it on exit
+func (a *autoRefresh) run(clock Clock, interval time.Duration, refresh func() error, ch chan error) {
+	defer close(a.done)
+	defer close(ch)
+	for {
+		timer := clock.NewTimer(interval)
+		select {
+		case <-a.quit:
+			timer.Stop()
+			return
+		case <-timer.C():
+			err := refresh()
+			if err == nil {
+				continue
+			}
+			select {
+			case ch <- err:
+			default:
+			}
+			switch err {
+			case ErrLockNotOwned, ErrLockNotHeld, ErrLockPersistent, ErrLockOrphaned:
+				return
+			}
+		}
+	}
+}
+
+// signal asks the goroutine to exit, without waiting for it
+func (a *autoRefresh) signal() {
+	a.once.Do(func() { close(a.quit) })
+}
+
+func (a *autoRefresh) stop() {
+	a.signal()
+	<-a.done
+}
+
+// stopping tells if the goroutine was asked to exit
+func (a *autoRefresh) stopping() bool {
+	select {
+	case <-a.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *autoRefresh) running() bool {
+	select {
+	case <-a.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// StartAutoRefresh starts refreshing the lock every interval (half the lock
+// TTL if interval <= 0) in a background goroutine, until stop is called, the
+// lock is released, or the lock is lost.
+// Refresh errors are sent on errs, which is closed when the goroutine exits;
+// errors are dropped while errs is full. ErrLockNotOwned, ErrLockNotHeld,
+// ErrLockPersistent and ErrLockOrphaned stop the refresh, as retrying can't
+// succeed.
+// If the lock is already being refreshed, errs only returns
+// ErrAutoRefreshRunning. stop is idempotent, and waits for the goroutine to
+// exit: it must not be called by the callbacks of the client options.
+// The lock itself is refreshed, like calling Refresh: its validity, Done
+// channel and events are updated as well.
+func (l *RedisLock) StartAutoRefresh(interval time.Duration) (stop func(), errs <-chan error) {
+	return l.startAutoRefresh(interval, nil)
+}
+
+// startAutoRefresh is StartAutoRefresh, calling refreshed, if not nil, after
+// every successful refresh
+func (l *RedisLock) startAutoRefresh(interval time.Duration, refreshed func()) (stop func(), errs <-chan error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	ch := make(chan error, 1)
+	if l.refresher != nil && l.refresher.running() {
+		ch <- ErrAutoRefreshRunning
+		close(ch)
+		return func() {}, ch
+	}
+	if interval <= 0 {
+		interval = l.ttl / 2
+	}
+	if interval <= 0 {
+		ch <- ErrLockNotHeld
+		close(ch)
+		return func() {}, ch
+	}
+
+	a := newAutoRefresh()
+	l.refresher = a
+	refresh := func() error {
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		if a.stopping() {
+			// stopped, i.e. released, while waiting for the lock
+			return nil
+		}
+		err := l.refreshContext(context.Background())
+		if err == nil && refreshed != nil {
+			refreshed()
+		}
+		return err
+	}
+	go a.run(l.client.opts.Clock, interval, refresh, ch)
+	return a.stop, ch
+}
+
+// StopAutoRefresh stops the refresh started by StartAutoRefresh, if any,
+// like calling the stop function it returned
+func (l *RedisLock) StopAutoRefresh() {
+	l.mtx.Lock()
+	a := l.refresher
+	l.refresher = nil
+	l.mtx.Unlock()
+	if a != nil {
+		a.stop()
+	}
+}
+
+// stopAutoRefresh asks the goroutine started by StartAutoRefresh to exit, if
+// any. It doesn't wait for it, as it's called with the lock mutex held: the
+// goroutine skips the refreshes waiting for the mutex.
+func (l *RedisLock) stopAutoRefresh() {
+	if l.refresher != nil {
+		l.refresher.signal()
+		l.refresher = nil
+	}
+}
+
+// clockOf returns the Clock of the client of l, SystemClock if the driver
+// doesn't have one
+func clockOf(l Lock) Clock {
+	if l, ok := l.(interface{ clock() Clock }); ok {
+		return l.clock()
+	}
+	return SystemClock
+}
+
+// AcquireAndKeepAlive acquires the lock for ttl and refreshes it in a
+// background goroutine every fraction of ttl (half of it if fraction is not
+// between 0 and 1), until stop is called or the lock is released or lost,
+// see StartAutoRefresh for errs and stop.
+// Redis locks are refreshed with StartAutoRefresh. Other locks are refreshed
+// directly: they must not be used until stop is called.
+func AcquireAndKeepAlive(l Lock, ttl time.Duration, fraction float64) (stop func(), errs <-chan error, err error) {
+	if err := l.Acquire(ttl); err != nil {
+		return nil, nil, err
+	}
+	if fraction <= 0 || fraction >= 1 {
+		fraction = 0.5
+	}
+	interval := time.Duration(float64(ttl) * fraction)
+	if redisLock, ok := l.(*RedisLock); ok {
+		stop, errs = redisLock.StartAutoRefresh(interval)
+		return stop, errs, nil
+	}
+	ch := make(chan error, 1)
+	a := newAutoRefresh()
+	go a.run(clockOf(l), interval, l.Refresh, ch)
+	return a.stop, ch, nil
+}
+
+// AcquireCtx acquires the lock for ttl and refreshes it every half ttl like
+// AcquireAndKeepAlive. It returns a context that is canceled as soon as the
+// lock is lost, released, or about to expire because the refreshes failed
+// for most of ttl. The returned cancel function stops the refreshes,
+// releases the lock and cancels the context; it is safe to call it more than
+// once. Refresh errors are dropped, only their outcome cancels the context.
+func AcquireCtx(l Lock, ttl time.Duration) (context.Context, func(), error) {
+	if err := l.Acquire(ttl); err != nil {
+		return nil, nil, err
+	}
+
+	clock := clockOf(l)
+	interval := ttl / 2
+	beats := make(chan struct{}, 1)
+	beat := func() {
+		select {
+		case beats <- struct{}{}:
+		default:
+		}
+	}
+	var stop func()
+	var errs <-chan error
+	if redisLock, ok := l.(*RedisLock); ok {
+		stop, errs = redisLock.startAutoRefresh(interval, beat)
+	} else {
+		ch := make(chan error, 1)
+		a := newAutoRefresh()
+		refresh := func() error {
+			err := l.Refresh()
+			if err == nil {
+				beat()
+			}
+			return err
+		}
+		go a.run(clock, interval, refresh, ch)
+		stop, errs = a.stop, ch
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer cancel()
+		// the lock expires ttl after the last successful refresh: give up a
+		// tenth of it earlier, leaving the caller time to stop using it
+		margin := ttl - ttl/10
+		timer := clock.NewTimer(margin)
+		defer func() { timer.Stop() }()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C():
+				return
+			case <-beats:
+				timer.Stop()
+				timer = clock.NewTimer(margin)
+			case _, ok := <-errs:
+				if !ok {
+					// the lock was lost or the refresh stopped
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			stop()
+			l.Release()
+			cancel()
+		})
+	}
+	return ctx, release, nil
+}
+
+// AcquireCtx acquires the lock for ttl, see AcquireCtx
+func (l *RedisLock) AcquireCtx(ttl time.Duration) (context.Context, func(), error) {
+	return AcquireCtx(l, ttl)
+}
@@ -0,0 +1,151 @@
+package glock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for durations to elapse. The memory driver
+// and the waits and refreshes run by glock (WaitAcquire, the auto refresh)
+// use it instead of the time package, so that tests can control them with a
+// ManualClock.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// NewTimer returns a timer sending the time on its channel once d
+	// elapsed
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock, like time.Timer
+type Timer interface {
+	// C returns the channel on which the time is sent when the timer fires
+	C() <-chan time.Time
+	// Stop stops the timer, telling if it was running
+	Stop() bool
+}
+
+// SystemClock is the Clock of the time package, used by default
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// ManualClock is a Clock whose time only moves with Advance, firing the
+// timers whose duration elapsed, i.e. to test TTLs and refreshes
+// deterministically. It is safe for concurrent use.
+type ManualClock struct {
+	mtx    sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManualClock returns a ManualClock set to now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now implements Clock
+func (c *ManualClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// NewTimer implements Clock. A timer of d <= 0 fires immediately
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	t := &manualTimer{clock: c, at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that expire in
+// order. Goroutines woken up by the timers may not have run yet when
+// Advance returns.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].at.Before(c.timers[j].at)
+	})
+	n := 0
+	for _, t := range c.timers {
+		if c.now.Before(t.at) {
+			c.timers[n] = t
+			n++
+			continue
+		}
+		t.ch <- t.at
+	}
+	c.timers = c.timers[:n]
+}
+
+// Timers returns the number of timers not fired nor stopped, i.e. to wait
+// for a goroutine to start waiting before advancing the clock
+func (c *ManualClock) Timers() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.timers)
+}
+
+// remove removes t from the pending timers, telling if it was pending
+func (c *ManualClock) remove(t *manualTimer) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type manualTimer struct {
+	clock *ManualClock
+	at    time.Time
+	ch    chan time.Time
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTimer) Stop() bool {
+	return t.clock.remove(t)
+}
+
+// funcClock tells the time with now, waiting with the timers of the time
+// package, see MemoryClient.SetClock
+type funcClock func() time.Time
+
+func (f funcClock) Now() time.Time {
+	return f()
+}
+
+func (f funcClock) NewTimer(d time.Duration) Timer {
+	return SystemClock.NewTimer(d)
+}
@@ -0,0 +1,102 @@
+package glock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Now()
+	clock := NewManualClock(start)
+	t1 := clock.NewTimer(time.Second)
+	t2 := clock.NewTimer(2 * time.Second)
+	t3 := clock.NewTimer(3 * time.Second)
+	if !t3.Stop() || t3.Stop() {
+		t.Errorf("Expected Stop to stop the timer once")
+	}
+	if n := clock.Timers(); n != 2 {
+		t.Errorf("Expected 2 pending timers, got %d", n)
+	}
+
+	clock.Advance(1500 * time.Millisecond)
+	if now := clock.Now(); !now.Equal(start.Add(1500 * time.Millisecond)) {
+		t.Errorf("Expected the clock to advance by 1.5s, got %v", now.Sub(start))
+	}
+	select {
+	case at := <-t1.C():
+		if !at.Equal(start.Add(time.Second)) {
+			t.Errorf("Expected the timer to fire at 1s, got %v", at.Sub(start))
+		}
+	default:
+		t.Errorf("Expected the 1s timer to fire")
+	}
+	select {
+	case <-t2.C():
+		t.Errorf("The 2s timer fired after 1.5s")
+	default:
+	}
+	if t1.Stop() {
+		t.Errorf("Stopping a fired timer should return false")
+	}
+	clock.Advance(time.Second)
+	if _, ok := <-t2.C(); !ok || clock.Timers() != 0 {
+		t.Errorf("Expected the 2s timer to fire")
+	}
+}
+
+func TestMemoryManualClock(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	c1 := NewMemoryClient("clock1")
+	c1.UseClock(clock)
+	c2 := NewMemoryClient("clock2")
+	c2.UseClock(clock)
+
+	lock1 := c1.NewLock("manual-clock")
+	stop, errs, err := AcquireAndKeepAlive(lock1, time.Minute, 0.5)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// the lock is refreshed every 30s of the clock
+	waitTimers(t, clock, 1)
+	clock.Advance(30 * time.Second)
+	waitTimers(t, clock, 1)
+	clock.Advance(20 * time.Second)
+	if ttl, err := lock1.RemainingTTL(); err != nil || ttl != 40*time.Second {
+		t.Errorf("Expected the lock refreshed after 30s, with 40s left, got %v %v", ttl, err)
+	}
+	stop()
+	if err, ok := <-errs; ok {
+		t.Errorf("Unexpected refresh error: %v", err)
+	}
+
+	// WaitAcquire retries with the backoff of the clock, until the lock expires
+	lock2 := c2.NewLock("manual-clock")
+	done := make(chan error)
+	go func() {
+		done <- lock2.WaitAcquire(context.Background(), time.Minute)
+	}()
+	waitTimers(t, clock, 1)
+	clock.Advance(40 * time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("Cannot wait for the lock: %s", err)
+	}
+	if err := lock1.Release(); err != ErrLockNotOwned {
+		t.Errorf("Expected ErrLockNotOwned, got %v", err)
+	}
+	if err := lock2.Release(); err != nil {
+		t.Errorf("Cannot release lock: %s", err)
+	}
+}
+
+// waitTimers waits for n timers to be pending on clock
+func waitTimers(t *testing.T, clock *ManualClock, n int) {
+	deadline := time.Now().Add(time.Second)
+	for clock.Timers() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d pending timers, got %d", n, clock.Timers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,544 @@
+package glock
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// clusterSlots is the number of hash slots of a redis cluster
+const clusterSlots = 16384
+
+// maxRedirects is the number of MOVED and ASK redirections followed by a
+// command before giving up
+const maxRedirects = 5
+
+// errConnClosed is returned by the commands run on a closed cluster connection
+var errConnClosed = errors.New("glock: cluster connection closed")
+
+// clustered tells if the client connects to a redis cluster, see
+// RedisOptions.ClusterAddresses
+func (c *RedisClient) clustered() bool {
+	return len(c.opts.ClusterAddresses) > 0
+}
+
+// redisCluster is the table of the masters serving the hash slots of a
+// redis cluster, shared by the connections of a client
+type redisCluster struct {
+	mtx   sync.RWMutex
+	slots []string
+}
+
+// loadCluster asks the cluster nodes, in order, for the masters serving the
+// slots, returning the table and the address of the node which replied
+func (c *RedisClient) loadCluster() (*redisCluster, string, error) {
+	var err error
+	for _, address := range c.opts.ClusterAddresses {
+		var conn redis.Conn
+		conn, err = c.dial(c.opts.DialFunc, address)
+		if err != nil {
+			continue
+		}
+		var reply []interface{}
+		reply, err = redis.Values(conn.Do("CLUSTER", "SLOTS"))
+		conn.Close()
+		if err != nil {
+			continue
+		}
+		cluster := &redisCluster{slots: make([]string, clusterSlots)}
+		if err = cluster.load(reply, address); err == nil {
+			return cluster, address, nil
+		}
+	}
+	return nil, "", err
+}
+
+// load fills the table from the reply of CLUSTER SLOTS, sent to the node
+// at address
+func (rc *redisCluster) load(reply []interface{}, address string) error {
+	host, _, _ := net.SplitHostPort(address)
+	for _, r := range reply {
+		var start, end int
+		var master []interface{}
+		if _, err := redis.Scan(r.([]interface{}), &start, &end, &master); err != nil {
+			return err
+		}
+		var ip string
+		var port int
+		if _, err := redis.Scan(master, &ip, &port); err != nil {
+			return err
+		}
+		// an empty ip is the ip of the node which replied
+		if ip == "" {
+			ip = host
+		}
+		if start < 0 || end >= clusterSlots || start > end {
+			return errors.New("glock: invalid cluster slot range " + strconv.Itoa(start) + "-" + strconv.Itoa(end))
+		}
+		for slot := start; slot <= end; slot++ {
+			rc.slots[slot] = net.JoinHostPort(ip, strconv.Itoa(port))
+		}
+	}
+	return nil
+}
+
+// master returns the address of the master serving slot, if known
+func (rc *redisCluster) master(slot int) string {
+	rc.mtx.RLock()
+	defer rc.mtx.RUnlock()
+	return rc.slots[slot]
+}
+
+// masters returns the addresses of the masters serving the slots
+func (rc *redisCluster) masters() []string {
+	rc.mtx.RLock()
+	defer rc.mtx.RUnlock()
+	var res []string
+	seen := make(map[string]bool)
+	for _, address := range rc.slots {
+		if address != "" && !seen[address] {
+			seen[address] = true
+			res = append(res, address)
+		}
+	}
+	return res
+}
+
+// redirected updates the table if err is a MOVED redirection, returning the
+// address the command must be sent to, and if it's an ASK redirection
+func (rc *redisCluster) redirected(err error) (string, bool, bool) {
+	e, ok := err.(redis.Error)
+	if !ok {
+		return "", false, false
+	}
+	fields := strings.Fields(string(e))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", false, false
+	}
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return "", false, false
+	}
+	if fields[0] == "ASK" {
+		return fields[2], true, true
+	}
+	rc.mtx.Lock()
+	rc.slots[slot] = fields[2]
+	rc.mtx.Unlock()
+	return fields[2], false, true
+}
+
+// isRedirectError tells if err is a MOVED or ASK redirection
+func isRedirectError(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && (strings.HasPrefix(string(e), "MOVED ") || strings.HasPrefix(string(e), "ASK "))
+}
+
+// redirects returns the number of redirected commands run retries, see
+// clusterConn
+func (c *RedisClient) redirects() int {
+	if !c.clustered() {
+		return 0
+	}
+	return maxRedirects
+}
+
+// slotTable returns the slot table of the cluster, nil if not connected to
+// a cluster
+func (c *RedisClient) slotTable() *redisCluster {
+	cluster, _ := c.cluster.Load().(*redisCluster)
+	return cluster
+}
+
+// keySlot returns the hash slot of key: the CRC16 of its hash tag (the part
+// between the first '{' and the following '}', if not empty) or of the whole
+// key, modulo the number of slots
+func keySlot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum used by redis cluster
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// keylessCommands are the commands not taking a key as first argument,
+// which are sent to the node of the previous command (or of the next one)
+var keylessCommands = map[string]bool{
+	"": true, "PING": true, "MULTI": true, "EXEC": true, "DISCARD": true,
+	"UNWATCH": true, "SCAN": true, "SCRIPT": true, "INFO": true, "TIME": true,
+	"ROLE": true, "CLUSTER": true, "ASKING": true, "AUTH": true, "SELECT": true,
+}
+
+// commandSlot returns the hash slot of the first key of the command, if any
+func commandSlot(cmd string, args []interface{}) (int, bool) {
+	cmd = strings.ToUpper(cmd)
+	if keylessCommands[cmd] {
+		return 0, false
+	}
+	if cmd == "EVAL" || cmd == "EVALSHA" {
+		// the keys follow the script and their number
+		if len(args) < 3 {
+			return 0, false
+		}
+		if n, ok := args[1].(int); !ok || n == 0 {
+			return 0, false
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch key := args[0].(type) {
+	case string:
+		return keySlot(key), true
+	case []byte:
+		return keySlot(string(key)), true
+	}
+	return 0, false
+}
+
+// clusterCmd is a command waiting for the node it will be sent to
+type clusterCmd struct {
+	name string
+	args []interface{}
+}
+
+// clusterConn is a connection to a redis cluster, sending each command to
+// the master serving the slot of its first key, through a connection to each
+// node used. Single commands follow the MOVED and ASK redirections; the
+// commands of pipelines and transactions (sent with Send) are not
+// redirected, but a MOVED reply updates the slot table for the next attempt
+// (see RedisClient.run). The keys of a transaction must share a slot.
+// Keyless commands are sent to the node of the previous command, or of the
+// next one (i.e. MULTI), or else to the node the slots were loaded from. Like
+// a redigo connection, it's broken by the first connection error.
+type clusterConn struct {
+	client  *RedisClient
+	cluster *redisCluster
+	seed    string
+
+	mtx    sync.Mutex
+	nodes  map[string]redis.Conn
+	err    error
+	closed bool
+
+	// last is the node of the last command sent, queued are the keyless
+	// commands sent before any node was known and pending are the nodes
+	// with a pending reply, in order
+	last    redis.Conn
+	queued  []clusterCmd
+	pending []redis.Conn
+	multi   bool
+	watched bool
+}
+
+// dialCluster returns a new connection to the cluster. The nodes are
+// connected on first use.
+func (c *RedisClient) dialCluster() *clusterConn {
+	return &clusterConn{
+		client:  c,
+		cluster: c.slotTable(),
+		seed:    c.MasterAddress(),
+		nodes:   make(map[string]redis.Conn),
+	}
+}
+
+// node returns the connection to the node at address, connecting if needed
+func (c *clusterConn) node(address string) (redis.Conn, error) {
+	c.mtx.Lock()
+	err, conn := c.err, c.nodes[address]
+	c.mtx.Unlock()
+	if err != nil || conn != nil {
+		return conn, err
+	}
+	conn, err = c.client.dial(c.client.opts.DialFunc, address)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		conn.Close()
+		return nil, c.err
+	}
+	c.nodes[address] = conn
+	return conn, nil
+}
+
+// route returns the connection to the node a command must be sent to
+func (c *clusterConn) route(cmd string, args []interface{}) (redis.Conn, error) {
+	if slot, ok := commandSlot(cmd, args); ok {
+		if address := c.cluster.master(slot); address != "" {
+			return c.node(address)
+		}
+	} else if c.last != nil {
+		return c.last, nil
+	}
+	return c.node(c.seed)
+}
+
+// fail breaks the connection after a connection error, closing the
+// connections to the nodes
+func (c *clusterConn) fail(err error) error {
+	if _, replied := err.(redis.Error); replied || err == nil {
+		return err
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for _, conn := range c.nodes {
+		conn.Close()
+	}
+	c.nodes = nil
+	c.last, c.queued, c.pending = nil, nil, nil
+	c.multi, c.watched = false, false
+	return err
+}
+
+// reset forgets the node of the last command once no reply is pending and
+// no transaction is open, so that the next commands are routed again
+func (c *clusterConn) reset() {
+	if len(c.pending) == 0 && len(c.queued) == 0 && !c.multi && !c.watched {
+		c.last = nil
+	}
+}
+
+// track updates the state of the transaction after sending cmd
+func (c *clusterConn) track(cmd string) {
+	switch strings.ToUpper(cmd) {
+	case "MULTI":
+		c.multi = true
+	case "WATCH":
+		c.watched = true
+	case "UNWATCH":
+		c.watched = false
+	case "EXEC", "DISCARD":
+		c.multi, c.watched = false, false
+	}
+}
+
+// Close closes the connections to the nodes. It may be called while a
+// command is running, to interrupt it.
+func (c *clusterConn) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.closed {
+		c.closed = true
+		if c.err == nil {
+			c.err = errConnClosed
+		}
+		for _, conn := range c.nodes {
+			conn.Close()
+		}
+		c.nodes = nil
+	}
+	return nil
+}
+
+// Err returns the error which broke the connection, if any
+func (c *clusterConn) Err() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.err
+}
+
+// Do implements redis.Conn
+func (c *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.DoWithTimeout(0, cmd, args...)
+}
+
+// DoWithTimeout implements redis.ConnWithTimeout. A single command follows
+// up to maxRedirects redirections.
+func (c *clusterConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.pending) > 0 || len(c.queued) > 0 || c.multi || c.watched {
+		return c.doPipelined(timeout, cmd, args)
+	}
+	if cmd == "" {
+		return nil, nil
+	}
+	conn, err := c.route(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	asking := false
+	for redirects := 0; ; redirects++ {
+		if asking {
+			conn.Send("ASKING")
+		}
+		var reply interface{}
+		reply, err = doWithTimeout(conn, timeout, cmd, args)
+		if err != nil {
+			if err = c.fail(err); c.Err() != nil {
+				return nil, err
+			}
+		}
+		address, ask, redirected := c.cluster.redirected(err)
+		if !redirected || redirects == maxRedirects {
+			c.last = conn
+			c.track(cmd)
+			c.reset()
+			return reply, err
+		}
+		if conn, err = c.node(address); err != nil {
+			return nil, err
+		}
+		asking = ask
+	}
+}
+
+// doPipelined sends cmd after the pending commands, returning its reply and
+// the first error replied, like redigo
+func (c *clusterConn) doPipelined(timeout time.Duration, cmd string, args []interface{}) (interface{}, error) {
+	if cmd != "" {
+		if err := c.Send(cmd, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	var reply interface{}
+	var replied error
+	for len(c.pending) > 0 {
+		var err error
+		if reply, err = c.ReceiveWithTimeout(timeout); err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				return nil, err
+			}
+			if replied == nil {
+				replied = err
+			}
+		}
+	}
+	return reply, replied
+}
+
+// Send implements redis.Conn
+func (c *clusterConn) Send(cmd string, args ...interface{}) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	_, keyed := commandSlot(cmd, args)
+	if !keyed && c.last == nil {
+		c.queued = append(c.queued, clusterCmd{cmd, args})
+		c.track(cmd)
+		return nil
+	}
+	conn, err := c.route(cmd, args)
+	if err != nil {
+		return err
+	}
+	for _, q := range c.queued {
+		if err = conn.Send(q.name, q.args...); err != nil {
+			return c.fail(err)
+		}
+		c.pending = append(c.pending, conn)
+	}
+	c.queued = nil
+	if err = conn.Send(cmd, args...); err != nil {
+		return c.fail(err)
+	}
+	c.pending = append(c.pending, conn)
+	c.last = conn
+	c.track(cmd)
+	return nil
+}
+
+// Flush implements redis.Conn, flushing the nodes with pending commands.
+// Commands queued only are sent to the node the slots were loaded from.
+func (c *clusterConn) Flush() error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	if len(c.queued) > 0 {
+		conn, err := c.node(c.seed)
+		if err != nil {
+			return err
+		}
+		c.last = conn
+		queued := c.queued
+		c.queued = nil
+		for _, q := range queued {
+			if err = conn.Send(q.name, q.args...); err != nil {
+				return c.fail(err)
+			}
+			c.pending = append(c.pending, conn)
+		}
+	}
+	flushed := make(map[redis.Conn]bool)
+	for _, conn := range c.pending {
+		if !flushed[conn] {
+			flushed[conn] = true
+			if err := conn.Flush(); err != nil {
+				return c.fail(err)
+			}
+		}
+	}
+	return nil
+}
+
+// Receive implements redis.Conn
+func (c *clusterConn) Receive() (interface{}, error) {
+	return c.ReceiveWithTimeout(0)
+}
+
+// ReceiveWithTimeout implements redis.ConnWithTimeout, receiving the reply
+// of the oldest pending command. MOVED replies update the slot table.
+func (c *clusterConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.pending) == 0 {
+		return nil, errors.New("glock: no pending cluster reply")
+	}
+	conn := c.pending[0]
+	c.pending = c.pending[1:]
+	var reply interface{}
+	var err error
+	if timeout > 0 {
+		reply, err = redis.ReceiveWithTimeout(conn, timeout)
+	} else {
+		reply, err = conn.Receive()
+	}
+	if err = c.fail(err); c.Err() != nil {
+		return nil, err
+	}
+	c.cluster.redirected(err)
+	c.reset()
+	return reply, err
+}
+
+// doWithTimeout runs a command on a node connection, with timeout if > 0
+func doWithTimeout(conn redis.Conn, timeout time.Duration, cmd string, args []interface{}) (interface{}, error) {
+	if timeout > 0 {
+		return redis.DoWithTimeout(conn, timeout, cmd, args...)
+	}
+	return conn.Do(cmd, args...)
+}
@@ -0,0 +1,73 @@
+package glock
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+)
+
+// Codec encodes and decodes typed values to and from lock data
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes lock data as JSON. It is the default codec.
+type JSONCodec struct{}
+
+// Marshal encodes v as JSON
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes lock data with encoding/gob. The result is binary, so it
+// is only suitable for backends storing data as raw bytes (i.e. redis).
+type GobCodec struct{}
+
+// Marshal encodes v with gob
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+// Unmarshal decodes gob data into v
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func encodeData(codec Codec, v interface{}) (string, error) {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// DataValue decodes the lock data into the value pointed by into, using the
+// codec of the client which returned the LockInfo (JSON if none is set).
+// Empty data sets into to its zero value.
+func (i *LockInfo) DataValue(into interface{}) error {
+	rv := reflect.ValueOf(into)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrInvalidDataValue
+	}
+	if i.Data == "" {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+		return nil
+	}
+	codec := i.codec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return codec.Unmarshal([]byte(i.Data), into)
+}
@@ -0,0 +1,221 @@
+package glock
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// waitersScriptText returns the owner of a lock ("" if free) followed by the
+// clients queued for it and not dropped yet, see RedisOptions.Fair
+const waitersScriptText = serverTimeScriptText + `
+local res = {redis.call("get", KEYS[1]) or ""}
+for _, waiter in ipairs(redis.call("zrange", KEYS[2], 0, -1)) do
+	local deadline = redis.call("hget", KEYS[3], waiter)
+	if deadline and tonumber(deadline) > now then
+		table.insert(res, waiter)
+	end
+end
+return res
+`
+
+var waitersScript = redis.NewScript(3, waitersScriptText)
+
+// DeadlockOptions configures a DeadlockDetector
+type DeadlockOptions struct {
+	// Interval is how often the locks are checked. Defaults to 10s
+	Interval time.Duration
+	// OnDeadlock is called once for each deadlock found
+	OnDeadlock func(d Deadlock)
+	// OnError is called if a check fails. Optional
+	OnError func(err error)
+}
+
+// Deadlock is a cycle of clients waiting for each other: Clients[i] waits
+// for Locks[i], held by Clients[i+1], and the last client waits for the last
+// lock, held by Clients[0].
+type Deadlock struct {
+	Locks   []string
+	Clients []string
+}
+
+// DeadlockDetector periodically builds the graph of the clients waiting for
+// a registered set of locks and of their holders, and reports its cycles.
+// The waiters are known only for the clients queueing with
+// RedisOptions.Fair. Deadlocks are reported, not resolved: they last until
+// one of the clients gives up waiting or a lock expires.
+type DeadlockDetector struct {
+	client   *RedisClient
+	names    []string
+	opts     DeadlockOptions
+	reported map[string]bool
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewDeadlockDetector returns a DeadlockDetector for the locks with the given
+// names. The detector uses its own connection, cloned from the client.
+func (c *RedisClient) NewDeadlockDetector(names []string, opts DeadlockOptions) *DeadlockDetector {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	return &DeadlockDetector{
+		client:   c.Clone().(*RedisClient),
+		names:    append([]string(nil), names...),
+		opts:     opts,
+		reported: make(map[string]bool),
+	}
+}
+
+// Start starts checking in a background goroutine, until Stop is called
+func (d *DeadlockDetector) Start() error {
+	err := d.client.Reconnect()
+	if err != nil {
+		return err
+	}
+	d.stop = make(chan struct{})
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer d.client.Close()
+		ticker := time.NewTicker(d.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				err := d.check()
+				if err != nil && d.opts.OnError != nil {
+					d.opts.OnError(err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the detector and waits for the background goroutine to exit
+func (d *DeadlockDetector) Stop() {
+	if d.stop != nil {
+		close(d.stop)
+		d.wg.Wait()
+		d.stop = nil
+	}
+}
+
+// deadlocks returns the deadlocks among the registered locks
+func (d *DeadlockDetector) deadlocks() ([]Deadlock, error) {
+	// waits maps each waiting client to the locks it waits for
+	waits := make(map[string][]string)
+	owners := make(map[string]string, len(d.names))
+	for _, name := range d.names {
+		l := d.client.newLock(name, "")
+		if l.err != nil {
+			return nil, l.err
+		}
+		reply, _, err := d.client.run(l.get, func(conn redis.Conn) (interface{}, error) {
+			return waitersScript.Do(conn, l.key(), l.queueKey(), l.deadlinesKey())
+		})
+		clients, err := redis.Strings(reply, err)
+		if err != nil {
+			return nil, opError("info", "EVALSHA", err)
+		}
+		if clients[0] == "" {
+			continue
+		}
+		owners[name] = clients[0]
+		for _, waiter := range clients[1:] {
+			waits[waiter] = append(waits[waiter], name)
+		}
+	}
+
+	// a depth-first search of the graph of the clients, whose edges are the
+	// locks waited for, finds a cycle for each edge back to a client on the
+	// current path
+	var waiters []string
+	for waiter := range waits {
+		waiters = append(waiters, waiter)
+	}
+	sort.Strings(waiters)
+	const (
+		unvisited = iota
+		onPath
+		visited
+	)
+	state := make(map[string]int)
+	var path, pathLocks []string
+	var deadlocks []Deadlock
+	var visit func(client string)
+	visit = func(client string) {
+		state[client] = onPath
+		path = append(path, client)
+		for _, name := range waits[client] {
+			owner, ok := owners[name]
+			if !ok {
+				continue
+			}
+			pathLocks = append(pathLocks, name)
+			switch state[owner] {
+			case unvisited:
+				visit(owner)
+			case onPath:
+				start := len(path) - 1
+				for path[start] != owner {
+					start--
+				}
+				deadlocks = append(deadlocks, Deadlock{
+					Clients: append([]string(nil), path[start:]...),
+					Locks:   append([]string(nil), pathLocks[start:]...),
+				})
+			}
+			pathLocks = pathLocks[:len(pathLocks)-1]
+		}
+		path = path[:len(path)-1]
+		state[client] = visited
+	}
+	for _, waiter := range waiters {
+		if state[waiter] == unvisited {
+			visit(waiter)
+		}
+	}
+	return deadlocks, nil
+}
+
+// check reports the deadlocks not reported yet. The deadlocks not found
+// anymore are reported again if they happen again.
+func (d *DeadlockDetector) check() error {
+	deadlocks, err := d.deadlocks()
+	if err != nil {
+		return err
+	}
+	found := make(map[string]bool, len(deadlocks))
+	for _, deadlock := range deadlocks {
+		id := deadlock.id()
+		found[id] = true
+		if !d.reported[id] && d.opts.OnDeadlock != nil {
+			d.opts.OnDeadlock(deadlock)
+		}
+	}
+	d.reported = found
+	return nil
+}
+
+// id identifies the deadlock regardless of the client its cycle starts from
+func (d Deadlock) id() string {
+	first := 0
+	for i, client := range d.Clients {
+		if client < d.Clients[first] {
+			first = i
+		}
+	}
+	var parts []string
+	for i := range d.Clients {
+		j := (first + i) % len(d.Clients)
+		parts = append(parts, d.Clients[j], d.Locks[j])
+	}
+	return strings.Join(parts, "\x00")
+}
@@ -0,0 +1,142 @@
+package glock
+
+import (
+	"context"
+	"time"
+)
+
+// lockDone is the channel returned by RedisLock.Done for an acquisition of
+// the lock. It's closed with l.mtx held.
+type lockDone struct {
+	ch chan struct{}
+	// acquiredAt identifies the acquisition, see RedisLock.acquiredAt
+	acquiredAt time.Time
+	err        error
+	closed     bool
+	// stop stops the goroutine watching the acquisition, see watchDone
+	stop func()
+}
+
+func (d *lockDone) close(err error) {
+	if d.closed {
+		return
+	}
+	d.closed, d.err = true, err
+	close(d.ch)
+	if d.stop != nil {
+		d.stop()
+	}
+}
+
+// Done returns a channel closed when the current acquisition of the lock
+// ends, so that the work protected by the lock can be aborted as soon as the
+// lock is lost: when a refresh finds it not owned anymore, when its validity
+// elapses without a refresh (see RemainingValidity), or when it is released
+// by this client. With DoneNotifications it's also closed when redis notifies
+// that the lock was released by another client (i.e. with ForceRelease) or
+// expired, see Watch. The channel of a lock not held is closed. LostErr tells
+// why the channel was closed.
+func (l *RedisLock) Done() <-chan struct{} {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if d := l.done; d != nil && (d.acquiredAt == l.acquiredAt || d.closed && l.acquiredBy == "") {
+		return d.ch
+	}
+	if l.done != nil {
+		// the lock was acquired again while held
+		l.done.close(nil)
+	}
+	d := &lockDone{ch: make(chan struct{}), acquiredAt: l.acquiredAt}
+	l.done = d
+	if l.acquiredBy == "" {
+		d.close(ErrLockNotHeld)
+		return d.ch
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.stop = cancel
+	go l.watchDone(ctx, d)
+	return d.ch
+}
+
+// LostErr returns why the channel returned by Done was closed:
+// ErrLockNotOwned if a refresh found the lock not owned anymore or if it was
+// released by another client, ErrLockExpired if redis notified its
+// expiration, ErrValidityElapsed if its validity elapsed without a refresh,
+// ErrLockNotHeld if it was not held when Done was called. It returns nil if
+// the channel is not closed, or if the lock was released by this client.
+func (l *RedisLock) LostErr() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.done == nil {
+		return nil
+	}
+	return l.done.err
+}
+
+// closeDone closes the Done channel of the current acquisition, if any,
+// with err. l.mtx must be held.
+func (l *RedisLock) closeDone(err error) {
+	if l.done != nil && l.done.acquiredAt == l.acquiredAt {
+		l.done.close(err)
+	}
+}
+
+// watchDone closes d when the validity of the acquisition elapses, or when
+// redis notifies that the lock was released or expired if DoneNotifications
+// is set, until ctx is done
+func (l *RedisLock) watchDone(ctx context.Context, d *lockDone) {
+	var events <-chan LockEvent
+	if l.client.opts.DoneNotifications {
+		// if the subscription fails, the lost lock is noticed by the refreshes
+		// or when its validity elapses
+		events, _ = l.client.watch(ctx, l)
+	}
+	for {
+		validity := l.RemainingValidity()
+		if validity <= 0 {
+			l.lostDone(d, ErrValidityElapsed)
+			return
+		}
+		var timer Timer
+		var timeout <-chan time.Time
+		if validity != NoExpiry {
+			timer = l.client.opts.Clock.NewTimer(validity)
+			timeout = timer.C()
+		}
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-timeout:
+		case event, ok := <-events:
+			switch {
+			case !ok:
+				events = nil
+			case event.Op == EventExpire:
+				err = ErrLockExpired
+			case event.Owner != l.client.ID():
+				// the releases of this client close d themselves
+				err = ErrLockNotOwned
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err == context.Canceled {
+			return
+		}
+		if err != nil {
+			l.lostDone(d, err)
+			return
+		}
+	}
+}
+
+// lostDone closes d with err, unless the acquisition ended already
+func (l *RedisLock) lostDone(d *lockDone, err error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if d.acquiredAt == l.acquiredAt {
+		d.close(err)
+	}
+}
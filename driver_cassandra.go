@@ -1,6 +1,7 @@
 package glock
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -14,16 +15,34 @@ const (
 	releaseQ    = `DELETE FROM %s.%s WHERE name = ? IF owner = ?`
 	refreshQ    = `UPDATE %s.%s USING TTL %d set owner = ?, data = ? WHERE name = ? IF owner = ?`
 	infoQ       = `SELECT owner, TTL(owner), data FROM %s.%s WHERE name = ?`
+	ttlQ        = `SELECT TTL(owner) FROM %s.%s WHERE name = ?`
 )
 
 // CassandraOptions represents options for connecting to cassandra
 type CassandraOptions struct {
-	Hosts             []string
-	KeySpace          string
-	Username          string
-	Password          string
-	TableName         string
+	// Hosts of the cassandra cluster
+	Hosts []string
+	// KeySpace of the locks table, created if missing
+	KeySpace string
+	// Username and Password are used to authenticate, if set
+	Username string
+	Password string
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// Namespace is prepended to TableName, so that independent sets of locks
+	// can share a keyspace. It must be a valid table name prefix, i.e.
+	// "glock_"
+	Namespace string
+	// TableName is the table of the locks, created if missing
+	TableName string
+	// ReplicationFactor of the keyspace, if created. Defaults to 1
 	ReplicationFactor int
+	// Consistency is the consistency level of the queries. Defaults to
+	// gocql.Quorum
+	Consistency gocql.Consistency
+	// SerialConsistency is the consistency level of the lightweight
+	// transactions (the conditional queries). Defaults to gocql.Serial
+	SerialConsistency gocql.SerialConsistency
 }
 
 // CassandraClient is the Client implementation for cassandra
@@ -36,6 +55,8 @@ type CassandraClient struct {
 	session      *gocql.Session
 	protoVersion int
 	consistency  gocql.Consistency
+	serial       gocql.SerialConsistency
+	auth         gocql.Authenticator
 }
 
 // CassandraLock is the Lock implementation for cassandra
@@ -52,50 +73,62 @@ func NewCassandraLockClient(opts CassandraOptions) (*CassandraClient, error) {
 	if opts.ReplicationFactor <= 0 {
 		opts.ReplicationFactor = 1
 	}
-	consistency := gocql.Quorum
-
-	if opts.ReplicationFactor == 0 {
-		consistency = gocql.One
+	consistency := opts.Consistency
+	if consistency == gocql.Any {
+		consistency = gocql.Quorum
+	}
+	serial := opts.SerialConsistency
+	if serial != gocql.LocalSerial {
+		serial = gocql.Serial
+	}
+	table := opts.Namespace + opts.TableName
+	var auth gocql.Authenticator
+	if opts.Username != "" {
+		auth = gocql.PasswordAuthenticator{Username: opts.Username, Password: opts.Password}
 	}
 
 	var session *gocql.Session
 	var err error
 	var c CassandraClient
 	for proto := 4; proto > 1; proto-- {
-		c = CassandraClient{nil, opts.Hosts, "", "", "", nil, proto, consistency}
+		c = CassandraClient{nil, opts.Hosts, "", "", "", nil, proto, consistency, serial, auth}
 		c.cluster = gocql.NewCluster(opts.Hosts...)
 		c.cluster.ProtoVersion = proto
+		c.cluster.Authenticator = auth
 		session, err = c.cluster.CreateSession()
 		if err == nil {
 			break
 		}
 	}
 	if err != nil {
-		return nil, err
+		return nil, backendError("cassandra", err)
 	}
 	err = session.Query(
 		fmt.Sprintf(createKs, opts.KeySpace, opts.ReplicationFactor),
 	).Exec()
 	if err != nil {
-		return nil, err
+		return nil, backendError("cassandra", err)
 	}
 
 	err = session.Query(
-		fmt.Sprintf(createTable, opts.KeySpace, opts.TableName),
+		fmt.Sprintf(createTable, opts.KeySpace, table),
 	).Exec()
 	if err != nil {
-		return nil, err
+		return nil, backendError("cassandra", err)
 	}
 
-	id, err := gocql.RandomUUID()
-	if err != nil {
-		return nil, err
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
 	}
 
 	c.hosts = opts.Hosts
 	c.keyspace = opts.KeySpace
-	c.table = opts.TableName
-	c.clientID = id.String()
+	c.table = table
+	c.clientID = opts.ClientID
 	c.Reconnect()
 
 	return &c, nil
@@ -104,13 +137,16 @@ func NewCassandraLockClient(opts CassandraOptions) (*CassandraClient, error) {
 // Clone returns a copy of the currenct client
 func (c *CassandraClient) Clone() Client {
 	return &CassandraClient{
-		cluster:     nil,
-		hosts:       c.hosts,
-		keyspace:    c.keyspace,
-		table:       c.table,
-		clientID:    c.clientID,
-		session:     nil,
-		consistency: c.consistency,
+		cluster:      nil,
+		hosts:        c.hosts,
+		keyspace:     c.keyspace,
+		table:        c.table,
+		clientID:     c.clientID,
+		session:      nil,
+		protoVersion: c.protoVersion,
+		consistency:  c.consistency,
+		serial:       c.serial,
+		auth:         c.auth,
 	}
 }
 
@@ -120,10 +156,12 @@ func (c *CassandraClient) Reconnect() error {
 	c.cluster = gocql.NewCluster(c.hosts...)
 	c.cluster.Keyspace = c.keyspace
 	c.cluster.Consistency = c.consistency
+	c.cluster.SerialConsistency = c.serial
 	c.cluster.ProtoVersion = c.protoVersion
+	c.cluster.Authenticator = c.auth
 	session, err := c.cluster.CreateSession()
 	if err != nil {
-		return err
+		return backendError("cassandra", err)
 	}
 	c.session = session
 	return nil
@@ -156,34 +194,66 @@ func (c *CassandraClient) NewLock(name string) Lock {
 	}
 }
 
+// AcquireMulti implements the Client interface
+func (c *CassandraClient) AcquireMulti(names []string, ttl time.Duration) ([]Lock, error) {
+	return acquireMulti(c, names, ttl)
+}
+
+// ReleaseMulti implements the Client interface
+func (c *CassandraClient) ReleaseMulti(locks []Lock) error {
+	return releaseMulti(locks)
+}
+
 // Acquire acquires the lock for the specified time lentgh (ttl).
 // It returns immadiately if the lock cannot be acquired
 func (l *CassandraLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext implements the Lock interface, running the query with ctx
+func (l *CassandraLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
 	var name, owner, data string
 	if ttl < time.Second {
 		return ErrInvalidTTL
 	}
 	l.ttl = ttl
 	query := fmt.Sprintf(acquireQ, l.client.keyspace, l.client.table, int(ttl.Seconds()))
-	applied, err := l.client.session.Query(query, l.name, l.owner, l.data).ScanCAS(&name, &owner, &data)
+	applied, err := l.client.session.Query(query, l.name, l.owner, l.data).WithContext(ctx).ScanCAS(&name, &owner, &data)
 	if err != nil {
-		return err
+		return backendError("cassandra", err)
 	}
-	if !applied && owner != l.owner {
+	// as with the other drivers, a lock held by this client isn't acquired
+	// again
+	if !applied {
 		return ErrLockHeldByOtherClient
 	}
 
 	return nil
 }
 
+// WaitAcquire implements the Lock interface
+func (l *CassandraLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquire(ctx, func() error { return l.AcquireContext(ctx, ttl) },
+		defaultWaitBackoff, defaultWaitMaxBackoff)
+}
+
 // Release releases the lock if owned. Returns an error if the lock is not owned by this client
 func (l *CassandraLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext implements the Lock interface, running the query with ctx
+func (l *CassandraLock) ReleaseContext(ctx context.Context) error {
 	var res string
 	query := fmt.Sprintf(releaseQ, l.client.keyspace,
 		l.client.table)
-	applied, err := l.client.session.Query(query, l.name, l.owner).ScanCAS(&res)
+	applied, err := l.client.session.Query(query, l.name, l.owner).WithContext(ctx).ScanCAS(&res)
 	if err != nil {
-		return err
+		return backendError("cassandra", err)
+	}
+	// the current owner is returned only if the lock exists
+	if !applied && res == "" {
+		return ErrLockExpired
 	}
 	if !applied {
 		return ErrLockNotOwned
@@ -199,10 +269,10 @@ func (l *CassandraLock) Info() (*LockInfo, error) {
 	query := fmt.Sprintf(infoQ, l.client.keyspace, l.client.table)
 	err := l.client.session.Query(query, l.name).SerialConsistency(gocql.Serial).Scan(&owner, &ttl, &data)
 	if err == gocql.ErrNotFound {
-		return &LockInfo{l.name, false, "", time.Duration(0), ""}, nil
+		return &LockInfo{Name: l.name, Acquired: false}, nil
 	}
 	if err != nil {
-		return nil, err
+		return nil, backendError("cassandra", err)
 	}
 	return &LockInfo{
 		Name:     l.name,
@@ -213,11 +283,30 @@ func (l *CassandraLock) Info() (*LockInfo, error) {
 	}, nil
 }
 
+// RemainingTTL returns the remaining TTL of the lock, 0 if not held.
+// Cassandra TTLs have a resolution of one second.
+func (l *CassandraLock) RemainingTTL() (time.Duration, error) {
+	var ttl int
+
+	query := fmt.Sprintf(ttlQ, l.client.keyspace, l.client.table)
+	err := l.client.session.Query(query, l.name).SerialConsistency(gocql.Serial).Scan(&ttl)
+	if err == gocql.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, backendError("cassandra", err)
+	}
+	return time.Duration(ttl) * time.Second, nil
+}
+
 // RefreshTTL Extends the lock, if owned, for the specified TTL.
 // ttl argument becomes the new ttl for the lock: successive calls to Refresh()
 // will use this ttl
 // It returns an error if the lock is not owned by the current client
 func (l *CassandraLock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Second {
+		return ErrInvalidTTL
+	}
 	l.ttl = ttl
 	return l.Refresh()
 }
@@ -225,14 +314,22 @@ func (l *CassandraLock) RefreshTTL(ttl time.Duration) error {
 // Refresh extends the lock by extending the TTL in the store.
 // It returns an error if the lock is not owned by the current client
 func (l *CassandraLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext implements the Lock interface, running the query with ctx
+func (l *CassandraLock) RefreshContext(ctx context.Context) error {
 	var name string
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
 	if l.ttl < time.Second {
 		return ErrInvalidTTL
 	}
 	query := fmt.Sprintf(refreshQ, l.client.keyspace, l.client.table, int(l.ttl.Seconds()))
-	applied, err := l.client.session.Query(query, l.owner, l.data, l.name, l.owner).ScanCAS(&name)
+	applied, err := l.client.session.Query(query, l.owner, l.data, l.name, l.owner).WithContext(ctx).ScanCAS(&name)
 	if err != nil {
-		return err
+		return backendError("cassandra", err)
 	}
 	if !applied {
 		return ErrLockNotOwned
@@ -6,6 +6,8 @@ import (
 	"flag"
 	"testing"
 	"time"
+
+	"github.com/gocql/gocql"
 )
 
 var host = flag.String("host", "127.0.0.1", "Cassandra host")
@@ -36,3 +38,129 @@ func TestCassandraClient(t *testing.T) {
 func TestCassandraLock(t *testing.T) {
 	testLock(t, cassandraClient, time.Second)
 }
+
+func TestCassandraLockRemainingTTL(t *testing.T) {
+	testLockRemainingTTL(t, cassandraClient, time.Second)
+}
+
+func TestCassandraLockWaitAcquire(t *testing.T) {
+	testLockWaitAcquire(t, cassandraClient, time.Second)
+}
+
+func TestCassandraAcquireWait(t *testing.T) {
+	testAcquireWait(t, cassandraClient, time.Second)
+}
+
+func TestCassandraAcquireAndKeepAlive(t *testing.T) {
+	testAcquireAndKeepAlive(t, cassandraClient, time.Second)
+}
+
+func TestCassandraLockContext(t *testing.T) {
+	testLockContext(t, cassandraClient, time.Second)
+}
+
+func TestCassandraAcquireMulti(t *testing.T) {
+	testAcquireMulti(t, cassandraClient, time.Second)
+}
+
+func TestCassandraLockRefreshNotHeld(t *testing.T) {
+	testLockRefreshNotHeld(t, cassandraClient, time.Second)
+}
+
+func TestCassandraNamespace(t *testing.T) {
+	c1 := cassandraClient(t).(*CassandraClient)
+	defer c1.Close()
+	c2, err := NewCassandraLockClient(CassandraOptions{
+		Hosts:             []string{*host},
+		KeySpace:          *keyspace,
+		Username:          *username,
+		Password:          *password,
+		Namespace:         "other_",
+		TableName:         "locks",
+		ReplicationFactor: 1,
+		SerialConsistency: gocql.LocalSerial,
+	})
+	if err != nil {
+		t.Fatalf("Cannot create cassandra client: %s", err)
+	}
+	defer c2.Close()
+	if c2.table != "other_locks" {
+		t.Errorf("Expected table other_locks, got %s", c2.table)
+	}
+
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+	if err = lock1.Acquire(time.Second); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer lock1.Release()
+	if err = lock2.Acquire(time.Second); err != nil {
+		t.Errorf("Locks in different namespaces should not conflict: %s", err)
+	}
+	lock2.Release()
+}
+
+func TestCassandraClientID(t *testing.T) {
+	c, err := NewCassandraLockClient(CassandraOptions{
+		Hosts:             []string{*host},
+		KeySpace:          *keyspace,
+		Username:          *username,
+		Password:          *password,
+		ClientID:          "cassandra-client",
+		TableName:         "locks",
+		ReplicationFactor: 1,
+	})
+	if err != nil {
+		t.Fatalf("Cannot create cassandra client: %s", err)
+	}
+	defer c.Close()
+	if c.ID() != "cassandra-client" {
+		t.Errorf("Expected client ID cassandra-client, got %s", c.ID())
+	}
+	lock := c.NewLock(lockName)
+	if err = lock.Acquire(time.Second); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer lock.Release()
+	if info, err := lock.Info(); err != nil || info.Owner != "cassandra-client" {
+		t.Errorf("Expected lock owned by cassandra-client, got %+v %v", info, err)
+	}
+}
+
+func TestCassandraReacquire(t *testing.T) {
+	c1 := cassandraClient(t)
+	defer c1.Close()
+	c2 := cassandraClient(t)
+	defer c2.Close()
+	same := cassandraClient(t)
+	defer same.Close()
+	same.SetID(c1.ID())
+
+	lock1 := c1.NewLock(lockName)
+	if err := lock1.Acquire(5 * time.Second); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer lock1.Release()
+	// like the redis driver, a lock held by this client isn't acquired again
+	if err := lock1.Acquire(5 * time.Second); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient acquiring the lock again, got %v", err)
+	}
+	if err := same.NewLock(lockName).Acquire(5 * time.Second); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient acquiring the lock with the same ID, got %v", err)
+	}
+	if err := c2.NewLock(lockName).Acquire(5 * time.Second); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient acquiring the lock of another client, got %v", err)
+	}
+	if info, err := lock1.Info(); err != nil || info.Owner != c1.ID() {
+		t.Errorf("Expected the lock still owned by %s, got %+v %v", c1.ID(), info, err)
+	}
+
+	if err := lock1.Release(); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	lock2 := c2.NewLock(lockName)
+	if err := lock2.Acquire(5 * time.Second); err != nil {
+		t.Errorf("Cannot acquire the released lock: %s", err)
+	}
+	lock2.Release()
+}
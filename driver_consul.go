@@ -0,0 +1,430 @@
+// +build consul
+
+package glock
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	consulMinTTL = 10 * time.Second
+	consulMaxTTL = 24 * time.Hour
+)
+
+// ConsulOptions represents options for connecting to consul
+type ConsulOptions struct {
+	// Address of the consul agent, i.e. 'localhost:8500'.
+	// Defaults to the consul client defaults (CONSUL_HTTP_ADDR)
+	Address string
+	// Datacenter to use. Defaults to the datacenter of the agent
+	Datacenter string
+	// Token is the ACL token used for the requests, if set
+	Token string
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// Namespace is the prefix of all the consul keys that will be created.
+	// Defaults to "glock/"
+	Namespace string
+}
+
+// ConsulClient is the Client implementation for consul.
+// Each lock is a KV key acquired with a session created with the lock TTL,
+// the same way consul's own locks work. Sessions may outlive their TTL (consul
+// invalidates them within twice the TTL), so the key value also holds the
+// expiration of the lock, which is the authoritative one: an expired lock is
+// taken over even if its session is still alive.
+// Consul sessions TTL must be between 10s and 24h.
+// The driver is built with the consul build tag.
+type ConsulClient struct {
+	opts   ConsulOptions
+	id     string
+	client *api.Client
+}
+
+// ConsulLock is the Lock implementation for consul
+type ConsulLock struct {
+	name   string
+	ttl    time.Duration
+	client *ConsulClient
+	data   string
+	// session is the session holding the lock, if acquired by this object,
+	// created for sessionTTL
+	session    string
+	sessionTTL time.Duration
+}
+
+// consulValue is the value of the lock key
+type consulValue struct {
+	Owner   string `json:"owner"`
+	Data    string `json:"data"`
+	Expires int64  `json:"expires"`
+}
+
+// NewConsulClient creates a new client from options
+func NewConsulClient(opts ConsulOptions) (*ConsulClient, error) {
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "glock/"
+	}
+	c := &ConsulClient{opts: opts, id: opts.ClientID}
+	if err := c.Reconnect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ID implements the Client interface
+func (c *ConsulClient) ID() string {
+	return c.id
+}
+
+// SetID implements the Client interface
+func (c *ConsulClient) SetID(id string) {
+	c.id = id
+}
+
+// Reconnect implements the Client interface
+func (c *ConsulClient) Reconnect() error {
+	cfg := api.DefaultConfig()
+	if c.opts.Address != "" {
+		cfg.Address = c.opts.Address
+	}
+	cfg.Datacenter = c.opts.Datacenter
+	cfg.Token = c.opts.Token
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return backendError("consul", err)
+	}
+	c.client = client
+	return nil
+}
+
+// Close implements the Client interface
+func (c *ConsulClient) Close() {
+	c.client = nil
+}
+
+// Clone implements the Client interface
+func (c *ConsulClient) Clone() Client {
+	return &ConsulClient{opts: c.opts, id: c.id}
+}
+
+// NewLock implements the Client interface
+func (c *ConsulClient) NewLock(name string) Lock {
+	return &ConsulLock{name: name, client: c}
+}
+
+// AcquireMulti implements the Client interface
+func (c *ConsulClient) AcquireMulti(names []string, ttl time.Duration) ([]Lock, error) {
+	return acquireMulti(c, names, ttl)
+}
+
+// ReleaseMulti implements the Client interface
+func (c *ConsulClient) ReleaseMulti(locks []Lock) error {
+	return releaseMulti(locks)
+}
+
+func (l *ConsulLock) key() string {
+	return l.client.opts.Namespace + l.name
+}
+
+func validConsulTTL(ttl time.Duration) bool {
+	return ttl >= consulMinTTL && ttl <= consulMaxTTL
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired.
+func (l *ConsulLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext implements the Lock interface
+func (l *ConsulLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !validConsulTTL(ttl) {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	session, err := l.newSession(ctx, ttl)
+	if err != nil {
+		return err
+	}
+	ok, err := l.lock(ctx, session, ttl)
+	if err == nil && !ok {
+		// the lock might be expired while its session is still alive
+		ok, err = l.takeOver(ctx, session, ttl)
+	}
+	if err != nil || !ok {
+		l.client.client.Session().Destroy(session, nil)
+		if err != nil {
+			return err
+		}
+		return ErrLockHeldByOtherClient
+	}
+	l.session, l.sessionTTL = session, ttl
+	return nil
+}
+
+// WaitAcquire implements the Lock interface
+func (l *ConsulLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquire(ctx, func() error { return l.AcquireContext(ctx, ttl) },
+		defaultWaitBackoff, defaultWaitMaxBackoff)
+}
+
+// newSession creates a session for ttl, deleting the locks it holds when
+// invalidated
+func (l *ConsulLock) newSession(ctx context.Context, ttl time.Duration) (string, error) {
+	id, _, err := l.client.client.Session().Create(&api.SessionEntry{
+		Name:      l.client.id,
+		TTL:       ttl.String(),
+		Behavior:  api.SessionBehaviorDelete,
+		LockDelay: time.Millisecond,
+	}, writeOptions(ctx))
+	return id, backendError("consul", err)
+}
+
+// lock acquires the key with the session, storing the lock value
+func (l *ConsulLock) lock(ctx context.Context, session string, ttl time.Duration) (bool, error) {
+	value, err := l.value(ttl)
+	if err != nil {
+		return false, err
+	}
+	ok, _, err := l.client.client.KV().Acquire(&api.KVPair{
+		Key:     l.key(),
+		Value:   value,
+		Session: session,
+	}, writeOptions(ctx))
+	return ok, backendError("consul", err)
+}
+
+// takeOver deletes the key of an expired lock, if not modified in the
+// meantime, and acquires it
+func (l *ConsulLock) takeOver(ctx context.Context, session string, ttl time.Duration) (bool, error) {
+	pair, _, err := l.client.client.KV().Get(l.key(), queryOptions(ctx))
+	if err != nil || pair == nil {
+		return false, backendError("consul", err)
+	}
+	if _, held := decodeConsulValue(pair); held {
+		return false, nil
+	}
+	ok, _, err := l.client.client.KV().DeleteCAS(&api.KVPair{
+		Key:         l.key(),
+		ModifyIndex: pair.ModifyIndex,
+	}, writeOptions(ctx))
+	if err != nil || !ok {
+		return false, backendError("consul", err)
+	}
+	return l.lock(ctx, session, ttl)
+}
+
+func (l *ConsulLock) value(ttl time.Duration) ([]byte, error) {
+	return json.Marshal(consulValue{
+		Owner:   l.client.id,
+		Data:    l.data,
+		Expires: time.Now().Add(ttl).UnixNano(),
+	})
+}
+
+// decodeConsulValue returns the value of the lock key and whether the lock
+// is held, that is the key is locked by a session and not expired
+func decodeConsulValue(pair *api.KVPair) (*consulValue, bool) {
+	if pair == nil || pair.Session == "" {
+		return nil, false
+	}
+	var v consulValue
+	if err := json.Unmarshal(pair.Value, &v); err != nil {
+		return nil, false
+	}
+	return &v, time.Now().UnixNano() < v.Expires
+}
+
+// get returns the lock key and whether it's held by this object
+func (l *ConsulLock) get(ctx context.Context) (*api.KVPair, bool, error) {
+	pair, _, err := l.client.client.KV().Get(l.key(), queryOptions(ctx))
+	if err != nil {
+		return nil, false, backendError("consul", err)
+	}
+	_, held := decodeConsulValue(pair)
+	return pair, held && l.session != "" && pair.Session == l.session, nil
+}
+
+// Release releases the lock if owned, destroying its session.
+// Returns an error if the lock is not owned by this client
+func (l *ConsulLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext implements the Lock interface
+func (l *ConsulLock) ReleaseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pair, owned, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		if _, held := decodeConsulValue(pair); held {
+			return ErrLockNotOwned
+		}
+		l.destroy(ctx)
+		return ErrLockExpired
+	}
+	ok, _, err := l.client.client.KV().Release(&api.KVPair{
+		Key:     l.key(),
+		Session: l.session,
+	}, writeOptions(ctx))
+	if err != nil {
+		return backendError("consul", err)
+	}
+	l.destroy(ctx)
+	if !ok {
+		return ErrLockNotOwned
+	}
+	return nil
+}
+
+// destroy destroys the session of the lock, if any
+func (l *ConsulLock) destroy(ctx context.Context) {
+	if l.session != "" {
+		l.client.client.Session().Destroy(l.session, writeOptions(ctx))
+		l.session, l.sessionTTL = "", 0
+	}
+}
+
+// Info returns information about the lock.
+func (l *ConsulLock) Info() (*LockInfo, error) {
+	pair, _, err := l.client.client.KV().Get(l.key(), nil)
+	if err != nil {
+		return nil, backendError("consul", err)
+	}
+	v, held := decodeConsulValue(pair)
+	if !held {
+		return &LockInfo{Name: l.name, Acquired: false}, nil
+	}
+	return &LockInfo{
+		Name:     l.name,
+		Acquired: true,
+		Owner:    v.Owner,
+		TTL:      time.Duration(v.Expires - time.Now().UnixNano()),
+		Data:     v.Data,
+	}, nil
+}
+
+// RemainingTTL returns the remaining TTL of the lock, 0 if not held.
+func (l *ConsulLock) RemainingTTL() (time.Duration, error) {
+	info, err := l.Info()
+	if err != nil || !info.Acquired {
+		return 0, err
+	}
+	return info.TTL, nil
+}
+
+// RefreshTTL Extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *ConsulLock) RefreshTTL(ttl time.Duration) error {
+	if !validConsulTTL(ttl) {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	return l.Refresh()
+}
+
+// Refresh extends the lock by renewing its session.
+// It returns an error if the lock is not owned by the current client
+func (l *ConsulLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext implements the Lock interface. Sessions are renewed for the
+// TTL they were created with: if the TTL of the lock changed, the lock is
+// moved to a new session.
+func (l *ConsulLock) RefreshContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	if !validConsulTTL(l.ttl) {
+		return ErrInvalidTTL
+	}
+	if _, owned, err := l.get(ctx); err != nil || !owned {
+		if err != nil {
+			return err
+		}
+		return ErrLockNotOwned
+	}
+	if l.ttl != l.sessionTTL {
+		return l.renew(ctx)
+	}
+	entry, _, err := l.client.client.Session().Renew(l.session, writeOptions(ctx))
+	if err != nil {
+		return backendError("consul", err)
+	}
+	if entry == nil {
+		return ErrLockNotOwned
+	}
+	// locking the key again with the same session updates its value
+	ok, err := l.lock(ctx, l.session, l.ttl)
+	if err == nil && !ok {
+		return ErrLockNotOwned
+	}
+	return err
+}
+
+// renew moves the lock, if owned, to a new session created for its TTL
+func (l *ConsulLock) renew(ctx context.Context) error {
+	session, err := l.newSession(ctx, l.ttl)
+	if err != nil {
+		return err
+	}
+	value, err := l.value(l.ttl)
+	if err != nil {
+		return err
+	}
+	ok, _, _, err := l.client.client.Txn().Txn(api.TxnOps{
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVUnlock, Key: l.key(), Session: l.session}},
+		&api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVLock, Key: l.key(), Value: value, Session: session}},
+	}, queryOptions(ctx))
+	if err != nil || !ok {
+		l.client.client.Session().Destroy(session, nil)
+		if err != nil {
+			return backendError("consul", err)
+		}
+		return ErrLockNotOwned
+	}
+	l.destroy(ctx)
+	l.session, l.sessionTTL = session, l.ttl
+	return nil
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *ConsulLock) SetData(data string) {
+	l.data = data
+}
+
+func writeOptions(ctx context.Context) *api.WriteOptions {
+	return (&api.WriteOptions{}).WithContext(ctx)
+}
+
+func queryOptions(ctx context.Context) *api.QueryOptions {
+	return (&api.QueryOptions{}).WithContext(ctx)
+}
@@ -0,0 +1,61 @@
+// +build consul
+
+package glock
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+var consulAddress = flag.String("consul-address", "127.0.0.1:8500", "Consul address")
+
+// consul sessions TTL is at least 10s
+var consulScale = 10 * time.Second
+
+func consulClient(t *testing.T) Client {
+	c, err := NewConsulClient(ConsulOptions{
+		Address:   *consulAddress,
+		Namespace: "glock_test/",
+	})
+	if err != nil {
+		t.Fatalf("Cannot create consul client: %s", err)
+	}
+	return c
+}
+
+func TestConsulClient(t *testing.T) {
+	testClient(t, consulClient)
+}
+
+func TestConsulLock(t *testing.T) {
+	testLock(t, consulClient, consulScale)
+}
+
+func TestConsulLockRemainingTTL(t *testing.T) {
+	testLockRemainingTTL(t, consulClient, consulScale)
+}
+
+func TestConsulLockWaitAcquire(t *testing.T) {
+	testLockWaitAcquire(t, consulClient, consulScale)
+}
+
+func TestConsulAcquireWait(t *testing.T) {
+	testAcquireWait(t, consulClient, consulScale)
+}
+
+func TestConsulAcquireAndKeepAlive(t *testing.T) {
+	testAcquireAndKeepAlive(t, consulClient, consulScale)
+}
+
+func TestConsulLockContext(t *testing.T) {
+	testLockContext(t, consulClient, consulScale)
+}
+
+func TestConsulAcquireMulti(t *testing.T) {
+	testAcquireMulti(t, consulClient, consulScale)
+}
+
+func TestConsulLockRefreshNotHeld(t *testing.T) {
+	testLockRefreshNotHeld(t, consulClient, consulScale)
+}
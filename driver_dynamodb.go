@@ -0,0 +1,377 @@
+// +build dynamodb
+
+package glock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/gocql/gocql"
+)
+
+const (
+	dynamoAcquireCond = "attribute_not_exists(#n) OR #e <= :now"
+	dynamoOwnerCond   = "#o = :owner AND #e > :now"
+)
+
+// DynamoDBOptions represents options for connecting to DynamoDB
+type DynamoDBOptions struct {
+	// DB is the DynamoDB client to use. If not set, a client is created
+	// from Config
+	DB dynamodbiface.DynamoDBAPI
+	// Config is the AWS configuration of the client created if DB is not
+	// set, on top of the default session (environment, shared config)
+	Config *aws.Config
+	// TableName is the table of the locks. Defaults to "glock_locks".
+	// The table has a string hash key "name"
+	TableName string
+	// CreateTable creates the table, if missing, with on-demand capacity
+	CreateTable bool
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// Namespace is prepended to the lock names, so that independent sets of
+	// locks can share a table
+	Namespace string
+}
+
+// DynamoDBClient is the Client implementation for DynamoDB.
+// Each lock is an item acquired with a conditional PutItem, which only
+// overwrites expired locks, and released or refreshed with conditional
+// writes checking the owner. Expiration uses the clock of the clients, in
+// milliseconds; items also have an epoch seconds "ttl" attribute, so that
+// DynamoDB Time to Live can be enabled on the table to delete them.
+// The driver is built with the dynamodb build tag.
+type DynamoDBClient struct {
+	opts DynamoDBOptions
+	id   string
+	db   dynamodbiface.DynamoDBAPI
+}
+
+// DynamoDBLock is the Lock implementation for DynamoDB
+type DynamoDBLock struct {
+	name   string
+	ttl    time.Duration
+	client *DynamoDBClient
+	data   string
+}
+
+// NewDynamoDBClient creates a new client from options, creating the table if
+// requested
+func NewDynamoDBClient(opts DynamoDBOptions) (*DynamoDBClient, error) {
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.TableName == "" {
+		opts.TableName = "glock_locks"
+	}
+	c := &DynamoDBClient{opts: opts, id: opts.ClientID}
+	if err := c.Reconnect(); err != nil {
+		return nil, err
+	}
+	if opts.CreateTable {
+		if err := c.createTable(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *DynamoDBClient) createTable() error {
+	_, err := c.db.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String(c.opts.TableName),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{{
+			AttributeName: aws.String("name"),
+			AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		}},
+		KeySchema: []*dynamodb.KeySchemaElement{{
+			AttributeName: aws.String("name"),
+			KeyType:       aws.String(dynamodb.KeyTypeHash),
+		}},
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceInUseException {
+		return nil
+	}
+	if err != nil {
+		return backendError("dynamodb", err)
+	}
+	err = c.db.WaitUntilTableExists(&dynamodb.DescribeTableInput{
+		TableName: aws.String(c.opts.TableName),
+	})
+	return backendError("dynamodb", err)
+}
+
+// ID implements the Client interface
+func (c *DynamoDBClient) ID() string {
+	return c.id
+}
+
+// SetID implements the Client interface
+func (c *DynamoDBClient) SetID(id string) {
+	c.id = id
+}
+
+// Reconnect implements the Client interface.
+// DynamoDB is accessed over HTTP, so this only creates the client if needed.
+func (c *DynamoDBClient) Reconnect() error {
+	if c.opts.DB != nil {
+		c.db = c.opts.DB
+		return nil
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return backendError("dynamodb", err)
+	}
+	if c.opts.Config != nil {
+		c.db = dynamodb.New(sess, c.opts.Config)
+	} else {
+		c.db = dynamodb.New(sess)
+	}
+	return nil
+}
+
+// Close implements the Client interface
+func (c *DynamoDBClient) Close() {
+}
+
+// Clone implements the Client interface
+func (c *DynamoDBClient) Clone() Client {
+	return &DynamoDBClient{opts: c.opts, id: c.id, db: c.db}
+}
+
+// NewLock implements the Client interface
+func (c *DynamoDBClient) NewLock(name string) Lock {
+	return &DynamoDBLock{name: name, client: c}
+}
+
+// AcquireMulti implements the Client interface
+func (c *DynamoDBClient) AcquireMulti(names []string, ttl time.Duration) ([]Lock, error) {
+	return acquireMulti(c, names, ttl)
+}
+
+// ReleaseMulti implements the Client interface
+func (c *DynamoDBClient) ReleaseMulti(locks []Lock) error {
+	return releaseMulti(locks)
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func dynamoMillis(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))}
+}
+
+func (l *DynamoDBLock) key() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"name": {S: aws.String(l.client.opts.Namespace + l.name)},
+	}
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired.
+func (l *DynamoDBLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext implements the Lock interface
+func (l *DynamoDBLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	now := time.Now()
+	expires := now.Add(ttl)
+	item := l.key()
+	item["owner"] = &dynamodb.AttributeValue{S: aws.String(l.client.id)}
+	item["data"] = &dynamodb.AttributeValue{S: aws.String(l.data)}
+	item["expires"] = dynamoMillis(expires)
+	item["ttl"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expires.Unix()+1, 10))}
+	_, err := l.client.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(l.client.opts.TableName),
+		Item:                item,
+		ConditionExpression: aws.String(dynamoAcquireCond),
+		ExpressionAttributeNames: map[string]*string{
+			"#n": aws.String("name"),
+			"#e": aws.String("expires"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": dynamoMillis(now),
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrLockHeldByOtherClient
+	}
+	return backendError("dynamodb", err)
+}
+
+// WaitAcquire implements the Lock interface
+func (l *DynamoDBLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquire(ctx, func() error { return l.AcquireContext(ctx, ttl) },
+		defaultWaitBackoff, defaultWaitMaxBackoff)
+}
+
+// Release releases the lock if owned.
+// Returns an error if the lock is not owned by this client
+func (l *DynamoDBLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext implements the Lock interface
+func (l *DynamoDBLock) ReleaseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := l.client.db.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(l.client.opts.TableName),
+		Key:                       l.key(),
+		ConditionExpression:       aws.String(dynamoOwnerCond),
+		ExpressionAttributeNames:  l.ownerNames(),
+		ExpressionAttributeValues: l.ownerValues(),
+	})
+	if !isConditionFailed(err) {
+		return backendError("dynamodb", err)
+	}
+	info, err := l.info(ctx)
+	if err != nil {
+		return err
+	}
+	if info.Acquired {
+		return ErrLockNotOwned
+	}
+	return ErrLockExpired
+}
+
+func (l *DynamoDBLock) ownerNames() map[string]*string {
+	return map[string]*string{
+		"#o": aws.String("owner"),
+		"#e": aws.String("expires"),
+	}
+}
+
+func (l *DynamoDBLock) ownerValues() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		":owner": {S: aws.String(l.client.id)},
+		":now":   dynamoMillis(time.Now()),
+	}
+}
+
+// Info returns information about the lock.
+func (l *DynamoDBLock) Info() (*LockInfo, error) {
+	return l.info(context.Background())
+}
+
+func (l *DynamoDBLock) info(ctx context.Context) (*LockInfo, error) {
+	out, err := l.client.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(l.client.opts.TableName),
+		Key:            l.key(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, backendError("dynamodb", err)
+	}
+	info := &LockInfo{Name: l.name}
+	if out.Item == nil || out.Item["expires"] == nil {
+		return info, nil
+	}
+	expires, err := strconv.ParseInt(aws.StringValue(out.Item["expires"].N), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	ttl := time.Duration(expires)*time.Millisecond - time.Duration(time.Now().UnixNano())
+	if ttl <= 0 {
+		return info, nil
+	}
+	info.Acquired = true
+	info.TTL = ttl
+	if owner := out.Item["owner"]; owner != nil {
+		info.Owner = aws.StringValue(owner.S)
+	}
+	if data := out.Item["data"]; data != nil {
+		info.Data = aws.StringValue(data.S)
+	}
+	return info, nil
+}
+
+// RemainingTTL returns the remaining TTL of the lock, 0 if not held.
+func (l *DynamoDBLock) RemainingTTL() (time.Duration, error) {
+	info, err := l.Info()
+	if err != nil {
+		return 0, err
+	}
+	return info.TTL, nil
+}
+
+// RefreshTTL Extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *DynamoDBLock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	return l.Refresh()
+}
+
+// Refresh extends the lock by extending the TTL in the store.
+// It returns an error if the lock is not owned by the current client
+func (l *DynamoDBLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext implements the Lock interface
+func (l *DynamoDBLock) RefreshContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	if l.ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	expires := time.Now().Add(l.ttl)
+	values := l.ownerValues()
+	values[":data"] = &dynamodb.AttributeValue{S: aws.String(l.data)}
+	values[":expires"] = dynamoMillis(expires)
+	values[":ttl"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expires.Unix()+1, 10))}
+	names := l.ownerNames()
+	names["#d"] = aws.String("data")
+	names["#t"] = aws.String("ttl")
+	_, err := l.client.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(l.client.opts.TableName),
+		Key:                       l.key(),
+		UpdateExpression:          aws.String("SET #d = :data, #e = :expires, #t = :ttl"),
+		ConditionExpression:       aws.String(dynamoOwnerCond),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if isConditionFailed(err) {
+		return ErrLockNotOwned
+	}
+	return backendError("dynamodb", err)
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *DynamoDBLock) SetData(data string) {
+	l.data = data
+}
@@ -0,0 +1,66 @@
+// +build dynamodb
+
+package glock
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+var dynamodbEndpoint = flag.String("dynamodb-endpoint", "http://127.0.0.1:8000", "DynamoDB endpoint (i.e. DynamoDB local)")
+
+func dynamodbClient(t *testing.T) Client {
+	c, err := NewDynamoDBClient(DynamoDBOptions{
+		Config: &aws.Config{
+			Endpoint:    aws.String(*dynamodbEndpoint),
+			Region:      aws.String("us-east-1"),
+			Credentials: credentials.NewStaticCredentials("glock", "glock", ""),
+		},
+		TableName:   "glock_test_locks",
+		CreateTable: true,
+	})
+	if err != nil {
+		t.Fatalf("Cannot create dynamodb client: %s", err)
+	}
+	return c
+}
+
+func TestDynamoDBClient(t *testing.T) {
+	testClient(t, dynamodbClient)
+}
+
+func TestDynamoDBLock(t *testing.T) {
+	testLock(t, dynamodbClient, time.Second)
+}
+
+func TestDynamoDBLockRemainingTTL(t *testing.T) {
+	testLockRemainingTTL(t, dynamodbClient, time.Second)
+}
+
+func TestDynamoDBLockWaitAcquire(t *testing.T) {
+	testLockWaitAcquire(t, dynamodbClient, time.Second)
+}
+
+func TestDynamoDBAcquireWait(t *testing.T) {
+	testAcquireWait(t, dynamodbClient, time.Second)
+}
+
+func TestDynamoDBAcquireAndKeepAlive(t *testing.T) {
+	testAcquireAndKeepAlive(t, dynamodbClient, time.Second)
+}
+
+func TestDynamoDBLockContext(t *testing.T) {
+	testLockContext(t, dynamodbClient, time.Second)
+}
+
+func TestDynamoDBAcquireMulti(t *testing.T) {
+	testAcquireMulti(t, dynamodbClient, time.Second)
+}
+
+func TestDynamoDBLockRefreshNotHeld(t *testing.T) {
+	testLockRefreshNotHeld(t, dynamodbClient, time.Second)
+}
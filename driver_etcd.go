@@ -0,0 +1,331 @@
+// +build etcd
+
+package glock
+
+import (
+	"context"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/gocql/gocql"
+)
+
+// EtcdOptions represents options for connecting to etcd
+type EtcdOptions struct {
+	// Endpoints of the etcd cluster, i.e. 'localhost:2379'
+	Endpoints []string
+	// Username and Password are used to authenticate, if set
+	Username string
+	Password string
+	// DialTimeout is the timeout for connecting to the cluster. Defaults to 5s
+	DialTimeout time.Duration
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// Namespace is the prefix of all the etcd keys that will be created.
+	// Defaults to "glock/"
+	Namespace string
+}
+
+// EtcdClient is the Client implementation for etcd v3.
+// Each lock is a key holding the owner, attached to a lease with the lock
+// TTL: the lock is acquired with a transaction creating the key only if it
+// doesn't exist, and refreshed by keeping the lease alive. The lock data is
+// stored in a separate key attached to the same lease.
+// The driver is built with the etcd build tag.
+type EtcdClient struct {
+	opts   EtcdOptions
+	id     string
+	client *clientv3.Client
+}
+
+// EtcdLock is the Lock implementation for etcd
+type EtcdLock struct {
+	name   string
+	ttl    time.Duration
+	client *EtcdClient
+	data   string
+	// lease is the lease of the lock, if acquired by this object, granted
+	// for leaseTTL
+	lease    clientv3.LeaseID
+	leaseTTL time.Duration
+}
+
+// NewEtcdClient creates a new client from options, connecting to etcd
+func NewEtcdClient(opts EtcdOptions) (*EtcdClient, error) {
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "glock/"
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	c := &EtcdClient{opts: opts, id: opts.ClientID}
+	if err := c.Reconnect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ID implements the Client interface
+func (c *EtcdClient) ID() string {
+	return c.id
+}
+
+// SetID implements the Client interface
+func (c *EtcdClient) SetID(id string) {
+	c.id = id
+}
+
+// Reconnect implements the Client interface
+func (c *EtcdClient) Reconnect() error {
+	c.Close()
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   c.opts.Endpoints,
+		DialTimeout: c.opts.DialTimeout,
+		Username:    c.opts.Username,
+		Password:    c.opts.Password,
+	})
+	if err != nil {
+		return backendError("etcd", err)
+	}
+	c.client = client
+	return nil
+}
+
+// Close implements the Client interface
+func (c *EtcdClient) Close() {
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
+}
+
+// Clone implements the Client interface
+func (c *EtcdClient) Clone() Client {
+	return &EtcdClient{opts: c.opts, id: c.id}
+}
+
+// NewLock implements the Client interface
+func (c *EtcdClient) NewLock(name string) Lock {
+	return &EtcdLock{name: name, client: c}
+}
+
+// AcquireMulti implements the Client interface
+func (c *EtcdClient) AcquireMulti(names []string, ttl time.Duration) ([]Lock, error) {
+	return acquireMulti(c, names, ttl)
+}
+
+// ReleaseMulti implements the Client interface
+func (c *EtcdClient) ReleaseMulti(locks []Lock) error {
+	return releaseMulti(locks)
+}
+
+func (l *EtcdLock) key() string {
+	return l.client.opts.Namespace + "locks/" + l.name
+}
+
+func (l *EtcdLock) dataKey() string {
+	return l.client.opts.Namespace + "data/" + l.name
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired.
+// etcd leases have a resolution of one second.
+func (l *EtcdLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext implements the Lock interface
+func (l *EtcdLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Second {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	lease, err := l.client.client.Grant(ctx, int64(ttl.Seconds()))
+	if err != nil {
+		return backendError("etcd", err)
+	}
+	key := l.key()
+	resp, err := l.client.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, l.client.id, clientv3.WithLease(lease.ID)),
+			clientv3.OpPut(l.dataKey(), l.data, clientv3.WithLease(lease.ID))).
+		Commit()
+	if err == nil && resp.Succeeded {
+		l.lease, l.leaseTTL = lease.ID, ttl
+		return nil
+	}
+	l.client.client.Revoke(context.Background(), lease.ID)
+	if err != nil {
+		return backendError("etcd", err)
+	}
+	return ErrLockHeldByOtherClient
+}
+
+// WaitAcquire implements the Lock interface
+func (l *EtcdLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquire(ctx, func() error { return l.AcquireContext(ctx, ttl) },
+		defaultWaitBackoff, defaultWaitMaxBackoff)
+}
+
+// Release releases the lock if owned, revoking its lease.
+// Returns an error if the lock is not owned by this client
+func (l *EtcdLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext implements the Lock interface
+func (l *EtcdLock) ReleaseContext(ctx context.Context) error {
+	key := l.key()
+	resp, err := l.client.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", l.client.id)).
+		Then(clientv3.OpGet(key), clientv3.OpDelete(key), clientv3.OpDelete(l.dataKey())).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return backendError("etcd", err)
+	}
+	kvs := resp.Responses[0].GetResponseRange().Kvs
+	if !resp.Succeeded && len(kvs) == 0 {
+		return ErrLockExpired
+	}
+	if !resp.Succeeded {
+		return ErrLockNotOwned
+	}
+	l.client.client.Revoke(ctx, clientv3.LeaseID(kvs[0].Lease))
+	l.lease, l.leaseTTL = 0, 0
+	return nil
+}
+
+// Info returns information about the lock.
+func (l *EtcdLock) Info() (*LockInfo, error) {
+	ctx := context.Background()
+	resp, err := l.client.client.Txn(ctx).
+		Then(clientv3.OpGet(l.key()), clientv3.OpGet(l.dataKey())).
+		Commit()
+	if err != nil {
+		return nil, backendError("etcd", err)
+	}
+	kvs := resp.Responses[0].GetResponseRange().Kvs
+	if len(kvs) == 0 {
+		return &LockInfo{Name: l.name, Acquired: false}, nil
+	}
+	ttl, err := l.remaining(ctx, kvs[0].Lease)
+	if err != nil {
+		return nil, err
+	}
+	info := &LockInfo{
+		Name:     l.name,
+		Acquired: ttl > 0,
+		Owner:    string(kvs[0].Value),
+		TTL:      ttl,
+	}
+	if data := resp.Responses[1].GetResponseRange().Kvs; len(data) > 0 {
+		info.Data = string(data[0].Value)
+	}
+	return info, nil
+}
+
+// RemainingTTL returns the remaining TTL of the lock, 0 if not held.
+// etcd leases have a resolution of one second.
+func (l *EtcdLock) RemainingTTL() (time.Duration, error) {
+	ctx := context.Background()
+	resp, err := l.client.client.Get(ctx, l.key())
+	if err != nil {
+		return 0, backendError("etcd", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+	return l.remaining(ctx, resp.Kvs[0].Lease)
+}
+
+// remaining returns the remaining TTL of the lease, 0 if expired
+func (l *EtcdLock) remaining(ctx context.Context, id int64) (time.Duration, error) {
+	resp, err := l.client.client.TimeToLive(ctx, clientv3.LeaseID(id))
+	if err != nil {
+		return 0, backendError("etcd", err)
+	}
+	if resp.TTL <= 0 {
+		return 0, nil
+	}
+	return time.Duration(resp.TTL) * time.Second, nil
+}
+
+// RefreshTTL Extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *EtcdLock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Second {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	return l.Refresh()
+}
+
+// Refresh extends the lock by keeping its lease alive.
+// It returns an error if the lock is not owned by the current client
+func (l *EtcdLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext implements the Lock interface. Leases are renewed for the
+// TTL they were granted with: if the TTL of the lock changed (or the lock was
+// not acquired with this object), the lock is moved to a new lease.
+func (l *EtcdLock) RefreshContext(ctx context.Context) error {
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	if l.ttl < time.Second {
+		return ErrInvalidTTL
+	}
+	if l.lease == 0 || l.ttl != l.leaseTTL {
+		return l.renew(ctx)
+	}
+	_, err := l.client.client.KeepAliveOnce(ctx, l.lease)
+	if err == rpctypes.ErrLeaseNotFound {
+		return ErrLockNotOwned
+	}
+	return backendError("etcd", err)
+}
+
+// renew moves the lock, if owned, to a new lease granted for its TTL
+func (l *EtcdLock) renew(ctx context.Context) error {
+	lease, err := l.client.client.Grant(ctx, int64(l.ttl.Seconds()))
+	if err != nil {
+		return backendError("etcd", err)
+	}
+	key := l.key()
+	resp, err := l.client.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", l.client.id)).
+		Then(clientv3.OpGet(key),
+			clientv3.OpPut(key, l.client.id, clientv3.WithLease(lease.ID)),
+			clientv3.OpPut(l.dataKey(), l.data, clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil || !resp.Succeeded {
+		l.client.client.Revoke(context.Background(), lease.ID)
+		if err != nil {
+			return backendError("etcd", err)
+		}
+		return ErrLockNotOwned
+	}
+	old := resp.Responses[0].GetResponseRange().Kvs[0].Lease
+	l.client.client.Revoke(ctx, clientv3.LeaseID(old))
+	l.lease, l.leaseTTL = lease.ID, l.ttl
+	return nil
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *EtcdLock) SetData(data string) {
+	l.data = data
+}
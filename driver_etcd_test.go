@@ -0,0 +1,58 @@
+// +build etcd
+
+package glock
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+var etcdEndpoint = flag.String("etcd-endpoint", "127.0.0.1:2379", "etcd endpoint")
+
+func etcdClient(t *testing.T) Client {
+	c, err := NewEtcdClient(EtcdOptions{
+		Endpoints: []string{*etcdEndpoint},
+		Namespace: "glock_test/",
+	})
+	if err != nil {
+		t.Fatalf("Cannot create etcd client: %s", err)
+	}
+	return c
+}
+
+func TestEtcdClient(t *testing.T) {
+	testClient(t, etcdClient)
+}
+
+func TestEtcdLock(t *testing.T) {
+	testLock(t, etcdClient, time.Second)
+}
+
+func TestEtcdLockRemainingTTL(t *testing.T) {
+	testLockRemainingTTL(t, etcdClient, time.Second)
+}
+
+func TestEtcdLockWaitAcquire(t *testing.T) {
+	testLockWaitAcquire(t, etcdClient, time.Second)
+}
+
+func TestEtcdAcquireWait(t *testing.T) {
+	testAcquireWait(t, etcdClient, time.Second)
+}
+
+func TestEtcdAcquireAndKeepAlive(t *testing.T) {
+	testAcquireAndKeepAlive(t, etcdClient, time.Second)
+}
+
+func TestEtcdLockContext(t *testing.T) {
+	testLockContext(t, etcdClient, time.Second)
+}
+
+func TestEtcdAcquireMulti(t *testing.T) {
+	testAcquireMulti(t, etcdClient, time.Second)
+}
+
+func TestEtcdLockRefreshNotHeld(t *testing.T) {
+	testLockRefreshNotHeld(t, etcdClient, time.Second)
+}
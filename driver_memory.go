@@ -1,13 +1,21 @@
 package glock
 
 import (
+	"context"
 	"sync"
 	"time"
 )
 
 type locksDB struct {
 	mtx   *sync.RWMutex
-	locks map[string]*MemoryLock
+	locks map[string]*memoryEntry
+}
+
+// memoryEntry is a lock held in the locksDB
+type memoryEntry struct {
+	owner  string
+	data   string
+	expire time.Time
 }
 
 var db *locksDB
@@ -16,138 +24,269 @@ func initDB() {
 	if db == nil {
 		db = &locksDB{
 			mtx:   &sync.RWMutex{},
-			locks: make(map[string]*MemoryLock),
+			locks: make(map[string]*memoryEntry),
 		}
 	}
 }
 
+// get returns the entry of a lock, if held at now. Expired entries are
+// removed lazily, so mtx must be held for writing
+func (d *locksDB) get(name string, now time.Time) (*memoryEntry, bool) {
+	entry, ok := d.locks[name]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(entry.expire) {
+		delete(d.locks, name)
+		return nil, false
+	}
+	return entry, true
+}
+
+// MemoryLock is the Lock implementation of MemoryClient
 type MemoryLock struct {
 	name   string
 	ttl    time.Duration
 	data   string
 	client *MemoryClient
-	timer  *time.Timer
-	expire time.Time
 }
 
+// MemoryClient is an in-process Client implementation, with the same
+// semantics of the other drivers: it's meant for tests and single process
+// use. All the memory clients of the process share the same locks.
 type MemoryClient struct {
-	id string
+	id    string
+	clock Clock
 }
 
+// NewMemoryClient creates a new memory client with the given client ID
 func NewMemoryClient(id string) *MemoryClient {
 	initDB()
-	return &MemoryClient{id: id}
+	return &MemoryClient{id: id, clock: SystemClock}
 }
 
+// SetClock sets the function used by the client to tell the time, i.e. to
+// make the locks expire deterministically in tests. A nil now restores
+// time.Now. Clients sharing locks should share the clock too.
+// WaitAcquire and AcquireAndKeepAlive still wait in real time: use UseClock
+// to control them too.
+func (m *MemoryClient) SetClock(now func() time.Time) {
+	if now == nil {
+		m.clock = SystemClock
+		return
+	}
+	m.clock = funcClock(now)
+}
+
+// UseClock sets the Clock used by the client to tell the time and to wait
+// between the WaitAcquire attempts and the refreshes of AcquireAndKeepAlive,
+// i.e. a ManualClock. A nil clock restores SystemClock. Clients sharing
+// locks should share the clock too.
+func (m *MemoryClient) UseClock(clock Clock) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	m.clock = clock
+}
+
+// Clone implements the Client interface. The clone shares the clock
 func (m *MemoryClient) Clone() Client {
-	return NewMemoryClient(m.id)
+	c := NewMemoryClient(m.id)
+	c.clock = m.clock
+	return c
 }
 
+// Close implements the Client interface
 func (m *MemoryClient) Close() {
 	return
 }
 
+// Reconnect implements the Client interface
 func (m *MemoryClient) Reconnect() error {
 	return nil
 }
 
+// SetID implements the Client interface
 func (m *MemoryClient) SetID(id string) {
 	m.id = id
 }
 
+// ID implements the Client interface
 func (m *MemoryClient) ID() string {
 	return m.id
 }
 
+// NewLock implements the Client interface
 func (m *MemoryClient) NewLock(name string) Lock {
 	return &MemoryLock{name: name, client: m}
 }
 
+// AcquireMulti implements the Client interface
+func (m *MemoryClient) AcquireMulti(names []string, ttl time.Duration) ([]Lock, error) {
+	return acquireMulti(m, names, ttl)
+}
+
+// ReleaseMulti implements the Client interface
+func (m *MemoryClient) ReleaseMulti(locks []Lock) error {
+	return releaseMulti(locks)
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired.
 func (l *MemoryLock) Acquire(ttl time.Duration) error {
-	if ttl <= time.Millisecond {
+	if ttl < time.Millisecond {
 		return ErrInvalidTTL
 	}
 	l.ttl = ttl
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
-	_, ok := db.locks[l.name]
-	switch ok {
-	case true:
+	now := l.client.clock.Now()
+	if _, ok := db.get(l.name, now); ok {
 		return ErrLockHeldByOtherClient
-
-	case false:
-		l.timer = time.AfterFunc(l.ttl, func() {
-			db.mtx.Lock()
-			defer db.mtx.Unlock()
-			delete(db.locks, l.name)
-			l.timer = nil
-		})
-		l.expire = time.Now().Add(l.ttl)
-		db.locks[l.name] = l
+	}
+	db.locks[l.name] = &memoryEntry{
+		owner:  l.client.id,
+		data:   l.data,
+		expire: now.Add(l.ttl),
 	}
 	return nil
 }
 
+// AcquireContext implements the Lock interface. Memory operations don't
+// block, so ctx is only checked before acquiring the lock
+func (l *MemoryLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.Acquire(ttl)
+}
+
+// WaitAcquire implements the Lock interface
+func (l *MemoryLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquireNotified(ctx, l.client.clock, func() error { return l.Acquire(ttl) },
+		backoffDelay(defaultWaitBackoff, defaultWaitMaxBackoff, 0), nil)
+}
+
+// clock returns the Clock of the client, see AcquireAndKeepAlive
+func (l *MemoryLock) clock() Clock {
+	return l.client.clock
+}
+
+// Release releases the lock if owned.
+// Returns an error if the lock is not owned by this client
 func (l *MemoryLock) Release() error {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
-	lock, ok := db.locks[l.name]
+	entry, ok := db.get(l.name, l.client.clock.Now())
 	if !ok {
-		return ErrLockNotOwned
+		return ErrLockExpired
 	}
-	if lock.client.id != l.client.id {
+	if entry.owner != l.client.id {
 		return ErrLockNotOwned
 	}
-	lock.timer.Stop()
 	delete(db.locks, l.name)
-	l.timer = nil
 	return nil
 }
 
+// ReleaseContext implements the Lock interface, see AcquireContext
+func (l *MemoryLock) ReleaseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.Release()
+}
+
+// RefreshContext implements the Lock interface, see AcquireContext
+func (l *MemoryLock) RefreshContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.Refresh()
+}
+
+// Refresh extends the lock by extending the TTL in the store.
+// It returns an error if the lock is not owned by the current client
 func (l *MemoryLock) Refresh() error {
-	if l.ttl <= time.Millisecond {
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	if l.ttl < time.Millisecond {
 		return ErrInvalidTTL
 	}
 
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
-	lock, ok := db.locks[l.name]
-	if !ok {
+	now := l.client.clock.Now()
+	entry, ok := db.get(l.name, now)
+	if !ok || entry.owner != l.client.id {
 		return ErrLockNotOwned
 	}
-	if lock.client.id != l.client.id {
-		return ErrLockNotOwned
-	}
-	lock.timer.Reset(lock.ttl)
-	lock.expire = time.Now().Add(lock.ttl)
+	entry.expire = now.Add(l.ttl)
+	entry.data = l.data
 	return nil
 }
 
+// RefreshTTL Extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
 func (l *MemoryLock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
 	l.ttl = ttl
 	return l.Refresh()
 }
 
+// Info returns information about the lock.
 func (l *MemoryLock) Info() (*LockInfo, error) {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
-	lock, ok := db.locks[l.name]
+	now := l.client.clock.Now()
+	entry, ok := db.get(l.name, now)
 	if !ok {
 		return &LockInfo{Name: l.name, Acquired: false}, nil
 	}
 	return &LockInfo{
 		Name:     l.name,
 		Acquired: true,
-		Owner:    lock.client.id,
-		TTL:      lock.expire.Sub(time.Now()),
-		Data:     lock.data,
+		Owner:    entry.owner,
+		TTL:      entry.expire.Sub(now),
+		Data:     entry.data,
 	}, nil
 }
 
+// RemainingTTL returns the remaining TTL of the lock, 0 if not held.
+func (l *MemoryLock) RemainingTTL() (time.Duration, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	now := l.client.clock.Now()
+	entry, ok := db.get(l.name, now)
+	if !ok {
+		return 0, nil
+	}
+	return entry.expire.Sub(now), nil
+}
+
 // SetData sets the data payload for the lock.
-// The data is set into the backend only when the lock is acquired,
-// so any call to this method after acquisition won't update the value.
+// The data is set into the backend only when the lock is acquired or
+// refreshed: use UpdateData to update it while holding the lock.
 func (l *MemoryLock) SetData(data string) {
 	l.data = data
 }
+
+// UpdateData sets the data payload for the lock and updates it in the store,
+// keeping the TTL of the lock. It returns ErrLockNotOwned, leaving the data
+// payload unchanged, if the lock is not owned by this client.
+func (l *MemoryLock) UpdateData(data string) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	entry, ok := db.get(l.name, l.client.clock.Now())
+	if !ok || entry.owner != l.client.id {
+		return ErrLockNotOwned
+	}
+	l.data = data
+	entry.data = data
+	return nil
+}
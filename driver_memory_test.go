@@ -3,6 +3,7 @@
 package glock
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -27,6 +28,26 @@ func TestMemoryManagerFailReleaseAll(t *testing.T) {
 	testManagerFailReleaseAll(t, memoryClient, memoryScale)
 }
 
+func TestMemoryManagerAcquireNoWait(t *testing.T) {
+	testManagerAcquireNoWait(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerAcquireCtx(t *testing.T) {
+	testManagerAcquireCtx(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerHealthyHeartbeat(t *testing.T) {
+	testManagerHealthyHeartbeat(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerOnAcquired(t *testing.T) {
+	testManagerOnAcquired(t, memoryClient, memoryScale)
+}
+
+func TestMemoryManagerDeadManSwitch(t *testing.T) {
+	testManagerDeadManSwitch(t, memoryClient, memoryScale)
+}
+
 func TestMemoryClient(t *testing.T) {
 	testClient(t, memoryClient)
 }
@@ -34,3 +55,157 @@ func TestMemoryClient(t *testing.T) {
 func TestMemoryLock(t *testing.T) {
 	testLock(t, memoryClient, memoryScale)
 }
+
+func TestMemoryLockRemainingTTL(t *testing.T) {
+	testLockRemainingTTL(t, memoryClient, memoryScale)
+}
+
+func TestMemoryLockWaitAcquire(t *testing.T) {
+	testLockWaitAcquire(t, memoryClient, memoryScale)
+}
+
+func TestMemoryAcquireWait(t *testing.T) {
+	testAcquireWait(t, memoryClient, memoryScale)
+}
+
+// timeoutLock is a lock whose attempts to acquire time out in the backend
+type timeoutLock struct {
+	Lock
+}
+
+func (l timeoutLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquire(ctx, func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, time.Millisecond, time.Millisecond)
+}
+
+func TestMemoryAcquireWaitTimeout(t *testing.T) {
+	lock := timeoutLock{memoryClient(t).NewLock(lockName)}
+	if err := AcquireWait(lock, time.Second, 10*memoryScale); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline exceeded by the attempt, got %v", err)
+	}
+}
+
+func TestMemoryAcquireAndKeepAlive(t *testing.T) {
+	testAcquireAndKeepAlive(t, memoryClient, memoryScale)
+}
+
+func TestMemoryAcquireCtx(t *testing.T) {
+	testAcquireCtx(t, memoryClient, 10*memoryScale)
+}
+
+func TestMemoryLockContext(t *testing.T) {
+	testLockContext(t, memoryClient, memoryScale)
+}
+
+func TestMemoryAcquireMulti(t *testing.T) {
+	testAcquireMulti(t, memoryClient, memoryScale)
+}
+
+func TestMemoryLockRefreshNotHeld(t *testing.T) {
+	testLockRefreshNotHeld(t, memoryClient, memoryScale)
+}
+
+func TestMemoryLeaderElector(t *testing.T) {
+	testLeaderElector(t, memoryClient, memoryScale)
+}
+
+func TestMemoryClock(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	c1 := NewMemoryClient("client1")
+	c1.SetClock(clock)
+	c2 := NewMemoryClient("client2")
+	c2.SetClock(clock)
+
+	lock1 := c1.NewLock(lockName)
+	lock1.SetData("client1")
+	if err := lock1.Acquire(time.Minute); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	lock1.SetData("changed")
+	now = now.Add(20 * time.Second)
+	info, err := lock1.Info()
+	if err != nil || info.TTL != 40*time.Second || info.Data != "client1" {
+		t.Errorf("Expected 40s TTL and data client1, got %+v %v", info, err)
+	}
+
+	lock2 := c2.NewLock(lockName)
+	if err = lock2.Acquire(time.Minute); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+
+	// the lock expires with the clock
+	now = now.Add(40 * time.Second)
+	if ttl, err := lock1.RemainingTTL(); err != nil || ttl != 0 {
+		t.Errorf("Expected expired lock, got %v %v", ttl, err)
+	}
+	if err = lock1.Refresh(); err != ErrLockNotOwned {
+		t.Errorf("Expected ErrLockNotOwned, got %v", err)
+	}
+	if err = lock2.Acquire(time.Minute); err != nil {
+		t.Fatalf("Cannot acquire expired lock: %s", err)
+	}
+	if err = lock1.Release(); err != ErrLockNotOwned {
+		t.Errorf("Expected ErrLockNotOwned, got %v", err)
+	}
+	if err = lock2.Release(); err != nil {
+		t.Errorf("Cannot release lock: %s", err)
+	}
+}
+
+func TestMemoryUpdateData(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	c1 := NewMemoryClient("client1")
+	c1.SetClock(clock)
+	c2 := NewMemoryClient("client2")
+	c2.SetClock(clock)
+
+	lock1 := c1.NewLock(lockName).(*MemoryLock)
+	lock1.SetData("start")
+	if err := lock1.Acquire(time.Minute); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	now = now.Add(20 * time.Second)
+	if err := lock1.UpdateData("progress"); err != nil {
+		t.Fatalf("Cannot update data: %s", err)
+	}
+	info, err := c2.NewLock(lockName).Info()
+	if err != nil || info.Data != "progress" || info.TTL != 40*time.Second {
+		t.Errorf("Expected data progress and 40s TTL, got %+v %v", info, err)
+	}
+
+	// only the owner can update the data
+	lock2 := c2.NewLock(lockName).(*MemoryLock)
+	if err = lock2.UpdateData("stolen"); err != ErrLockNotOwned {
+		t.Errorf("Expected ErrLockNotOwned, got %v", err)
+	}
+	if lock2.data != "" {
+		t.Errorf("Expected data unchanged on failure, got %q", lock2.data)
+	}
+
+	if err = lock1.Release(); err != nil {
+		t.Fatalf("Cannot release lock: %s", err)
+	}
+	if err = lock1.UpdateData("released"); err != ErrLockNotOwned {
+		t.Errorf("Expected ErrLockNotOwned after release, got %v", err)
+	}
+
+	// nor after the lock expired, and the data acquired next is unchanged
+	if err = lock1.Acquire(time.Minute); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if err = lock1.UpdateData("expired"); err != ErrLockNotOwned {
+		t.Errorf("Expected ErrLockNotOwned after expiry, got %v", err)
+	}
+	if err = lock1.Acquire(time.Minute); err != nil {
+		t.Fatalf("Cannot acquire expired lock: %s", err)
+	}
+	defer lock1.Release()
+	if info, err := c2.NewLock(lockName).Info(); err != nil || info.Data != "progress" {
+		t.Errorf("Expected data progress, got %+v %v", info, err)
+	}
+}
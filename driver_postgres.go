@@ -0,0 +1,479 @@
+// +build postgres
+
+package glock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	pgCreateTable = `CREATE TABLE IF NOT EXISTS %s (name text PRIMARY KEY, owner text NOT NULL, data text NOT NULL, expires_at timestamptz NOT NULL)`
+	pgAcquireQ    = `INSERT INTO %s AS l (name, owner, data, expires_at) VALUES ($1, $2, $3, now() + $4 * interval '1 millisecond')
+		ON CONFLICT (name) DO UPDATE SET owner = EXCLUDED.owner, data = EXCLUDED.data, expires_at = EXCLUDED.expires_at
+		WHERE l.expires_at <= now()`
+	pgReleaseQ = `DELETE FROM %s WHERE name = $1 AND owner = $2 AND expires_at > now()`
+	pgRefreshQ = `UPDATE %s SET data = $3, expires_at = now() + $4 * interval '1 millisecond' WHERE name = $1 AND owner = $2 AND expires_at > now()`
+	pgInfoQ    = `SELECT owner, data, EXTRACT(EPOCH FROM expires_at - now()) FROM %s WHERE name = $1 AND expires_at > now()`
+
+	pgTryAdvisoryLockQ = `SELECT pg_try_advisory_lock($1)`
+	pgAdvisoryUnlockQ  = `SELECT pg_advisory_unlock($1)`
+	pgAdvisoryInfoQ    = `SELECT EXISTS (SELECT 1 FROM pg_locks WHERE locktype = 'advisory' AND granted
+		AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+		AND classid::bigint = $1 AND objid::bigint = $2 AND objsubid = 1)`
+)
+
+// PostgresOptions represents options for connecting to postgres
+type PostgresOptions struct {
+	// DriverName is the database/sql driver to use, which must be registered
+	// by the application (i.e. importing github.com/lib/pq).
+	// Defaults to "postgres"
+	DriverName string
+	// DataSource is the connection string passed to the driver
+	DataSource string
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// TableName is the table of the locks, created if missing.
+	// Defaults to "glock_locks"
+	TableName string
+	// Namespace is prepended to the lock names, so that independent sets of
+	// locks can share a table or the advisory locks of a database
+	Namespace string
+	// Advisory makes NewLock return advisory locks, which don't need a table
+	// but don't support TTLs and data (see PostgresAdvisoryLock)
+	Advisory bool
+}
+
+// PostgresClient is the Client implementation for postgres.
+// By default, locks are rows of a table, acquired with an upsert which only
+// overwrites expired locks: expiration uses the database clock. With the
+// Advisory option, locks are session level advisory locks instead.
+// Requires postgres >= 9.5 as it uses INSERT ... ON CONFLICT.
+// The driver is built with the postgres build tag.
+type PostgresClient struct {
+	opts PostgresOptions
+	id   string
+	db   *sql.DB
+}
+
+// PostgresLock is the table backed Lock implementation for postgres
+type PostgresLock struct {
+	name   string
+	ttl    time.Duration
+	client *PostgresClient
+	data   string
+}
+
+// PostgresAdvisoryLock is the Lock implementation using postgres advisory
+// locks (pg_try_advisory_lock). The lock is held by a dedicated connection
+// until released or until the connection is closed, so it has no TTL: the TTL
+// passed to Acquire is only validated, and Refresh only checks the connection
+// holding the lock is alive. Advisory locks have no data nor owner, so Info
+// only reports the owner and data of locks held by the same object.
+// Lock names are hashed into the advisory lock keys.
+type PostgresAdvisoryLock struct {
+	name   string
+	ttl    time.Duration
+	client *PostgresClient
+	data   string
+	// conn is the connection holding the lock, if acquired by this object
+	conn *sql.Conn
+}
+
+// NewPostgresClient creates a new client from options, connecting to
+// postgres and creating the locks table if needed
+func NewPostgresClient(opts PostgresOptions) (*PostgresClient, error) {
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.DriverName == "" {
+		opts.DriverName = "postgres"
+	}
+	if opts.TableName == "" {
+		opts.TableName = "glock_locks"
+	}
+	c := &PostgresClient{opts: opts, id: opts.ClientID}
+	if err := c.Reconnect(); err != nil {
+		return nil, err
+	}
+	if !opts.Advisory {
+		if _, err := c.db.Exec(fmt.Sprintf(pgCreateTable, opts.TableName)); err != nil {
+			c.Close()
+			return nil, backendError("postgres", err)
+		}
+	}
+	return c, nil
+}
+
+// ID implements the Client interface
+func (c *PostgresClient) ID() string {
+	return c.id
+}
+
+// SetID implements the Client interface
+func (c *PostgresClient) SetID(id string) {
+	c.id = id
+}
+
+// Reconnect implements the Client interface
+func (c *PostgresClient) Reconnect() error {
+	c.Close()
+	db, err := sql.Open(c.opts.DriverName, c.opts.DataSource)
+	if err != nil {
+		return backendError("postgres", err)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return backendError("postgres", err)
+	}
+	c.db = db
+	return nil
+}
+
+// Close implements the Client interface.
+// Closing the client closes the connections holding advisory locks, releasing
+// them.
+func (c *PostgresClient) Close() {
+	if c.db != nil {
+		c.db.Close()
+		c.db = nil
+	}
+}
+
+// Clone implements the Client interface
+func (c *PostgresClient) Clone() Client {
+	return &PostgresClient{opts: c.opts, id: c.id}
+}
+
+// NewLock implements the Client interface
+func (c *PostgresClient) NewLock(name string) Lock {
+	if c.opts.Advisory {
+		return &PostgresAdvisoryLock{name: name, client: c}
+	}
+	return &PostgresLock{name: name, client: c}
+}
+
+// AcquireMulti implements the Client interface
+func (c *PostgresClient) AcquireMulti(names []string, ttl time.Duration) ([]Lock, error) {
+	return acquireMulti(c, names, ttl)
+}
+
+// ReleaseMulti implements the Client interface
+func (c *PostgresClient) ReleaseMulti(locks []Lock) error {
+	return releaseMulti(locks)
+}
+
+func (c *PostgresClient) query(q string) string {
+	return fmt.Sprintf(q, c.opts.TableName)
+}
+
+func (l *PostgresLock) key() string {
+	return l.client.opts.Namespace + l.name
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired.
+func (l *PostgresLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext implements the Lock interface
+func (l *PostgresLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	res, err := l.client.db.ExecContext(ctx, l.client.query(pgAcquireQ),
+		l.key(), l.client.id, l.data, int64(ttl/time.Millisecond))
+	if err != nil {
+		return backendError("postgres", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err != nil {
+			return backendError("postgres", err)
+		}
+		return ErrLockHeldByOtherClient
+	}
+	return nil
+}
+
+// WaitAcquire implements the Lock interface
+func (l *PostgresLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquire(ctx, func() error { return l.AcquireContext(ctx, ttl) },
+		defaultWaitBackoff, defaultWaitMaxBackoff)
+}
+
+// Release releases the lock if owned.
+// Returns an error if the lock is not owned by this client
+func (l *PostgresLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext implements the Lock interface
+func (l *PostgresLock) ReleaseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	res, err := l.client.db.ExecContext(ctx, l.client.query(pgReleaseQ), l.key(), l.client.id)
+	if err != nil {
+		return backendError("postgres", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return backendError("postgres", err)
+	}
+	info, err := l.info(ctx)
+	if err != nil {
+		return err
+	}
+	if info.Acquired {
+		return ErrLockNotOwned
+	}
+	return ErrLockExpired
+}
+
+// Info returns information about the lock.
+func (l *PostgresLock) Info() (*LockInfo, error) {
+	return l.info(context.Background())
+}
+
+func (l *PostgresLock) info(ctx context.Context) (*LockInfo, error) {
+	info := &LockInfo{Name: l.name}
+	var ttl float64
+	err := l.client.db.QueryRowContext(ctx, l.client.query(pgInfoQ), l.key()).
+		Scan(&info.Owner, &info.Data, &ttl)
+	if err == sql.ErrNoRows {
+		return info, nil
+	}
+	if err != nil {
+		return nil, backendError("postgres", err)
+	}
+	info.Acquired = true
+	info.TTL = time.Duration(ttl * float64(time.Second))
+	return info, nil
+}
+
+// RemainingTTL returns the remaining TTL of the lock, 0 if not held.
+func (l *PostgresLock) RemainingTTL() (time.Duration, error) {
+	info, err := l.Info()
+	if err != nil {
+		return 0, err
+	}
+	return info.TTL, nil
+}
+
+// RefreshTTL Extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *PostgresLock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	return l.Refresh()
+}
+
+// Refresh extends the lock by extending the TTL in the store.
+// It returns an error if the lock is not owned by the current client
+func (l *PostgresLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext implements the Lock interface
+func (l *PostgresLock) RefreshContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	if l.ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	res, err := l.client.db.ExecContext(ctx, l.client.query(pgRefreshQ),
+		l.key(), l.client.id, l.data, int64(l.ttl/time.Millisecond))
+	if err != nil {
+		return backendError("postgres", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err != nil {
+			return backendError("postgres", err)
+		}
+		return ErrLockNotOwned
+	}
+	return nil
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *PostgresLock) SetData(data string) {
+	l.data = data
+}
+
+// key returns the advisory lock key of the lock
+func (l *PostgresAdvisoryLock) key() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(l.client.opts.Namespace + l.name))
+	return int64(h.Sum64())
+}
+
+// Acquire acquires the lock, on a dedicated connection.
+// It returns immadiately if the lock cannot be acquired.
+func (l *PostgresAdvisoryLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext implements the Lock interface
+func (l *PostgresAdvisoryLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	conn, err := l.client.db.Conn(ctx)
+	if err != nil {
+		return backendError("postgres", err)
+	}
+	var ok bool
+	err = conn.QueryRowContext(ctx, pgTryAdvisoryLockQ, l.key()).Scan(&ok)
+	if err != nil || !ok {
+		conn.Close()
+		if err != nil {
+			return backendError("postgres", err)
+		}
+		return ErrLockHeldByOtherClient
+	}
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	l.conn = conn
+	return nil
+}
+
+// WaitAcquire implements the Lock interface
+func (l *PostgresAdvisoryLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquire(ctx, func() error { return l.AcquireContext(ctx, ttl) },
+		defaultWaitBackoff, defaultWaitMaxBackoff)
+}
+
+// Release releases the lock if owned, closing its connection.
+// Returns an error if the lock is not owned by this object
+func (l *PostgresAdvisoryLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext implements the Lock interface
+func (l *PostgresAdvisoryLock) ReleaseContext(ctx context.Context) error {
+	if l.conn == nil {
+		acquired, err := l.acquired(ctx)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			return ErrLockNotOwned
+		}
+		return ErrLockExpired
+	}
+	var ok bool
+	err := backendError("postgres", l.conn.QueryRowContext(ctx, pgAdvisoryUnlockQ, l.key()).Scan(&ok))
+	if err == nil && !ok {
+		err = ErrLockExpired
+	}
+	if ctx.Err() == nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	return err
+}
+
+// acquired returns whether the advisory lock is held by any session
+func (l *PostgresAdvisoryLock) acquired(ctx context.Context) (bool, error) {
+	key := uint64(l.key())
+	var acquired bool
+	err := l.client.db.QueryRowContext(ctx, pgAdvisoryInfoQ,
+		int64(key>>32), int64(key&0xffffffff)).Scan(&acquired)
+	return acquired, backendError("postgres", err)
+}
+
+// Info returns information about the lock. Owner and Data are set only if
+// the lock is held by this object.
+func (l *PostgresAdvisoryLock) Info() (*LockInfo, error) {
+	acquired, err := l.acquired(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	info := &LockInfo{Name: l.name, Acquired: acquired}
+	if acquired {
+		info.TTL = NoExpiry
+	}
+	if acquired && l.conn != nil {
+		info.Owner, info.Data = l.client.id, l.data
+	}
+	return info, nil
+}
+
+// RemainingTTL returns NoExpiry if the lock is held, 0 otherwise.
+func (l *PostgresAdvisoryLock) RemainingTTL() (time.Duration, error) {
+	acquired, err := l.acquired(context.Background())
+	if err != nil || !acquired {
+		return 0, err
+	}
+	return NoExpiry, nil
+}
+
+// RefreshTTL checks the lock is still held by this object. The TTL is only
+// validated.
+func (l *PostgresAdvisoryLock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	return l.Refresh()
+}
+
+// Refresh checks the lock is still held by this object, that is its
+// connection is alive.
+func (l *PostgresAdvisoryLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext implements the Lock interface
+func (l *PostgresAdvisoryLock) RefreshContext(ctx context.Context) error {
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	if l.ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	if l.conn == nil {
+		return ErrLockNotOwned
+	}
+	if err := l.conn.PingContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the lock is lost with the connection
+		l.conn.Close()
+		l.conn = nil
+		return ErrLockNotOwned
+	}
+	return nil
+}
+
+// SetData sets the data payload for the lock. Advisory locks have no data:
+// it's only reported by Info on the same object.
+func (l *PostgresAdvisoryLock) SetData(data string) {
+	l.data = data
+}
@@ -0,0 +1,125 @@
+// +build postgres
+
+package glock
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+var postgresDSN = flag.String("postgres-dsn", "postgres://postgres@127.0.0.1/glock_test?sslmode=disable", "Postgres connection string")
+
+func postgresOptions() PostgresOptions {
+	return PostgresOptions{
+		DataSource: *postgresDSN,
+		TableName:  "glock_test_locks",
+	}
+}
+
+func newPostgresClient(t *testing.T, opts PostgresOptions) *PostgresClient {
+	c, err := NewPostgresClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create postgres client: %s", err)
+	}
+	return c
+}
+
+func postgresClient(t *testing.T) Client {
+	return newPostgresClient(t, postgresOptions())
+}
+
+func TestPostgresClient(t *testing.T) {
+	testClient(t, postgresClient)
+}
+
+func TestPostgresLock(t *testing.T) {
+	testLock(t, postgresClient, time.Second)
+}
+
+func TestPostgresLockRemainingTTL(t *testing.T) {
+	testLockRemainingTTL(t, postgresClient, time.Second)
+}
+
+func TestPostgresLockWaitAcquire(t *testing.T) {
+	testLockWaitAcquire(t, postgresClient, time.Second)
+}
+
+func TestPostgresAcquireWait(t *testing.T) {
+	testAcquireWait(t, postgresClient, time.Second)
+}
+
+func TestPostgresAcquireAndKeepAlive(t *testing.T) {
+	testAcquireAndKeepAlive(t, postgresClient, time.Second)
+}
+
+func TestPostgresLockContext(t *testing.T) {
+	testLockContext(t, postgresClient, time.Second)
+}
+
+func TestPostgresAcquireMulti(t *testing.T) {
+	testAcquireMulti(t, postgresClient, time.Second)
+}
+
+func TestPostgresLockRefreshNotHeld(t *testing.T) {
+	testLockRefreshNotHeld(t, postgresClient, time.Second)
+}
+
+func TestPostgresAdvisoryLock(t *testing.T) {
+	opts := postgresOptions()
+	opts.Advisory = true
+	c1 := newPostgresClient(t, opts)
+	c2 := newPostgresClient(t, opts)
+
+	lock1 := c1.NewLock(lockName)
+	lock1.SetData("client1")
+	lock2 := c2.NewLock(lockName)
+	if err := lock1.Acquire(time.Second); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	if err := lock1.Acquire(time.Second); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+	if err := lock2.Acquire(time.Second); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+
+	// advisory locks don't expire
+	time.Sleep(2 * time.Second)
+	info, err := lock1.Info()
+	if err != nil || !info.Acquired || info.TTL != NoExpiry || info.Owner != c1.ID() || info.Data != "client1" {
+		t.Errorf("Expected lock held by %s with no expiry, got %+v %v", c1.ID(), info, err)
+	}
+	info, err = lock2.Info()
+	if err != nil || !info.Acquired || info.Owner != "" {
+		t.Errorf("Expected lock held with no owner, got %+v %v", info, err)
+	}
+	if err := lock1.Refresh(); err != nil {
+		t.Errorf("Cannot refresh lock '%s': %s", lockName, err)
+	}
+	if err := lock2.Refresh(); err != ErrLockNotOwned {
+		t.Errorf("Expected ErrLockNotOwned, got %v", err)
+	}
+	if err := lock2.Release(); err != ErrLockNotOwned {
+		t.Errorf("Expected ErrLockNotOwned, got %v", err)
+	}
+
+	if err := lock1.Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+	if err := lock1.Release(); err != ErrLockExpired {
+		t.Errorf("Expected ErrLockExpired, got %v", err)
+	}
+
+	// closing the client releases its locks
+	if err := lock2.Acquire(time.Second); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	c2.Close()
+	if err := lock1.Acquire(time.Second); err != nil {
+		t.Fatalf("Cannot acquire lock released by closing the client: %s", err)
+	}
+	lock1.Release()
+}
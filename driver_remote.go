@@ -0,0 +1,316 @@
+package glock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// RemoteOptions represents options for connecting to a lock service, see
+// the glockserver package
+type RemoteOptions struct {
+	// URL is the base URL of the lock service, i.e. 'http://localhost:8080'
+	URL string
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// HTTPClient is used to send the requests. Defaults to a client with a
+	// 10s timeout
+	HTTPClient *http.Client
+}
+
+// RemoteClient is the Client implementation for a lock service exposing the
+// locks of another driver over HTTP, see the glockserver package. The locks
+// have the semantics of the driver of the service.
+type RemoteClient struct {
+	opts RemoteOptions
+	id   string
+}
+
+// RemoteLock is the Lock implementation of RemoteClient
+type RemoteLock struct {
+	name   string
+	ttl    time.Duration
+	data   string
+	client *RemoteClient
+}
+
+// RemoteRequest is the JSON body of the requests to the lock service, posted
+// to /v1/acquire, /v1/refresh, /v1/release, /v1/info and /v1/ttl
+type RemoteRequest struct {
+	// Name is the lock name
+	Name string `json:"name"`
+	// Owner is the ID of the client doing the operation. It's required by
+	// acquire, refresh and release
+	Owner string `json:"owner,omitempty"`
+	// TTL is the TTL of acquire and refresh, in milliseconds
+	TTL int64 `json:"ttl_ms,omitempty"`
+	// Data is the data set by acquire and refresh
+	Data string `json:"data,omitempty"`
+}
+
+// RemoteResponse is the JSON body of the responses of the lock service
+type RemoteResponse struct {
+	// Info is the lock information returned by info, and by ttl with only
+	// the TTL set
+	Info *RemoteInfo `json:"info,omitempty"`
+	// Error is the message of the error of the operation, if any
+	Error string `json:"error,omitempty"`
+}
+
+// RemoteInfo is the JSON encoding of a LockInfo
+type RemoteInfo struct {
+	Name     string `json:"name"`
+	Acquired bool   `json:"acquired"`
+	Owner    string `json:"owner,omitempty"`
+	// TTL is in milliseconds, rounded up. It's -1 for the locks without
+	// expiry
+	TTL   int64  `json:"ttl_ms"`
+	Data  string `json:"data,omitempty"`
+	Clock uint64 `json:"clock,omitempty"`
+	Token uint64 `json:"token,omitempty"`
+	// OwnerMetadata is set if recorded by the driver of the service
+	OwnerMetadata *OwnerMetadata `json:"owner_metadata,omitempty"`
+}
+
+// NewRemoteInfo encodes info
+func NewRemoteInfo(info *LockInfo) *RemoteInfo {
+	ttl := int64(-1)
+	if info.TTL != NoExpiry {
+		ttl = int64((info.TTL + time.Millisecond - 1) / time.Millisecond)
+	}
+	return &RemoteInfo{
+		Name:     info.Name,
+		Acquired: info.Acquired,
+		Owner:    info.Owner,
+		TTL:      ttl,
+		Data:     info.Data,
+		Clock:    info.Clock,
+		Token:    info.Token,
+
+		OwnerMetadata: info.OwnerMetadata,
+	}
+}
+
+// LockInfo decodes the info
+func (i *RemoteInfo) LockInfo() *LockInfo {
+	ttl := NoExpiry
+	if i.TTL >= 0 {
+		ttl = time.Duration(i.TTL) * time.Millisecond
+	}
+	return &LockInfo{
+		Name:     i.Name,
+		Acquired: i.Acquired,
+		Owner:    i.Owner,
+		TTL:      ttl,
+		Data:     i.Data,
+		Clock:    i.Clock,
+		Token:    i.Token,
+
+		OwnerMetadata: i.OwnerMetadata,
+	}
+}
+
+// NewRemoteClient creates a new client of the lock service at opts.URL. No
+// request is sent until the first operation.
+func NewRemoteClient(opts RemoteOptions) (*RemoteClient, error) {
+	if opts.URL == "" {
+		return nil, errors.New("Remote lock service URL is required")
+	}
+	opts.URL = strings.TrimRight(opts.URL, "/")
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &RemoteClient{opts: opts, id: opts.ClientID}, nil
+}
+
+// Clone implements the Client interface
+func (c *RemoteClient) Clone() Client {
+	opts := c.opts
+	opts.ClientID = c.id
+	return &RemoteClient{opts: opts, id: c.id}
+}
+
+// Close implements the Client interface. The service is stateless, so there
+// is no connection to close
+func (c *RemoteClient) Close() {
+	return
+}
+
+// Reconnect implements the Client interface, see Close
+func (c *RemoteClient) Reconnect() error {
+	return nil
+}
+
+// SetID implements the Client interface
+func (c *RemoteClient) SetID(id string) {
+	c.id = id
+}
+
+// ID implements the Client interface
+func (c *RemoteClient) ID() string {
+	return c.id
+}
+
+// NewLock implements the Client interface
+func (c *RemoteClient) NewLock(name string) Lock {
+	return &RemoteLock{name: name, client: c}
+}
+
+// AcquireMulti implements the Client interface
+func (c *RemoteClient) AcquireMulti(names []string, ttl time.Duration) ([]Lock, error) {
+	return acquireMulti(c, names, ttl)
+}
+
+// ReleaseMulti implements the Client interface
+func (c *RemoteClient) ReleaseMulti(locks []Lock) error {
+	return releaseMulti(locks)
+}
+
+// do posts req to the op endpoint of the service. The errors replied by the
+// service are restored as the package errors, if any, and are wrapped in a
+// BackendError otherwise, like the transport errors.
+func (c *RemoteClient) do(ctx context.Context, op string, req RemoteRequest) (*RemoteResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, c.opts.URL+"/v1/"+op, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := c.opts.HTTPClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, backendError("remote", err)
+	}
+	defer httpResp.Body.Close()
+	var resp RemoteResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, backendError("remote", errors.New(httpResp.Status))
+	}
+	if resp.Error != "" {
+		err := sentinelError(resp.Error)
+		if httpResp.StatusCode >= http.StatusInternalServerError {
+			err = backendError("remote", err)
+		}
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// millis returns ttl in milliseconds
+func millis(ttl time.Duration) int64 {
+	return int64(ttl / time.Millisecond)
+}
+
+// Acquire acquires the lock for the specified time length (ttl).
+// It returns immediately if the lock cannot be acquired.
+func (l *RemoteLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext implements the Lock interface
+func (l *RemoteLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	_, err := l.client.do(ctx, "acquire", RemoteRequest{Name: l.name, Owner: l.client.id, TTL: millis(ttl), Data: l.data})
+	return err
+}
+
+// WaitAcquire implements the Lock interface. The service is polled with
+// backoff while the lock is held by another client
+func (l *RemoteLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquire(ctx, func() error { return l.AcquireContext(ctx, ttl) },
+		defaultWaitBackoff, defaultWaitMaxBackoff)
+}
+
+// Release releases the lock if owned.
+// Returns an error if the lock is not owned by this client
+func (l *RemoteLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext implements the Lock interface
+func (l *RemoteLock) ReleaseContext(ctx context.Context) error {
+	_, err := l.client.do(ctx, "release", RemoteRequest{Name: l.name, Owner: l.client.id})
+	return err
+}
+
+// Refresh extends the lock by extending the TTL in the store.
+// It returns an error if the lock is not owned by the current client
+func (l *RemoteLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext implements the Lock interface
+func (l *RemoteLock) RefreshContext(ctx context.Context) error {
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	_, err := l.client.do(ctx, "refresh", RemoteRequest{Name: l.name, Owner: l.client.id, TTL: millis(l.ttl), Data: l.data})
+	return err
+}
+
+// RefreshTTL Extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *RemoteLock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	return l.Refresh()
+}
+
+// Info returns information about the lock.
+func (l *RemoteLock) Info() (*LockInfo, error) {
+	resp, err := l.client.do(context.Background(), "info", RemoteRequest{Name: l.name})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Info == nil {
+		return nil, backendError("remote", errors.New("info missing from the response"))
+	}
+	return resp.Info.LockInfo(), nil
+}
+
+// RemainingTTL returns the remaining TTL of the lock, 0 if not held.
+func (l *RemoteLock) RemainingTTL() (time.Duration, error) {
+	resp, err := l.client.do(context.Background(), "ttl", RemoteRequest{Name: l.name})
+	if err != nil {
+		return 0, err
+	}
+	if resp.Info == nil {
+		return 0, backendError("remote", errors.New("info missing from the response"))
+	}
+	return resp.Info.LockInfo().TTL, nil
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired or
+// refreshed.
+func (l *RemoteLock) SetData(data string) {
+	l.data = data
+}
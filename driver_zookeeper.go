@@ -0,0 +1,508 @@
+// +build zookeeper
+
+package glock
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const zookeeperNodePrefix = "lock-"
+
+// ZookeeperOptions represents options for connecting to zookeeper
+type ZookeeperOptions struct {
+	// Servers of the zookeeper ensemble, i.e. 'localhost:2181'
+	Servers []string
+	// SessionTimeout is the timeout of the zookeeper session. Defaults to 10s
+	SessionTimeout time.Duration
+	// ClientID is the current client ID. If not set, it will be autogenerated
+	ClientID string
+	// Namespace is the path of the parent znode of all the locks.
+	// Defaults to "/glock"
+	Namespace string
+	// SessionLocks makes the locks last as long as the zookeeper session of
+	// the client holding them, instead of expiring with their TTL: the TTL
+	// is only validated, and Refresh only checks the lock is still held.
+	SessionLocks bool
+	// Fair makes WaitAcquire queue for the lock: waiting clients acquire it
+	// in the order they started waiting, instead of polling for it.
+	Fair bool
+}
+
+// ZookeeperClient is the Client implementation for zookeeper.
+// Each lock is a znode whose children are ephemeral sequential nodes, one for
+// each client acquiring or waiting for the lock: the lock is held by the
+// client owning the lowest node. Nodes are deleted by zookeeper when the
+// session of their client ends, so locks held by dead clients are released
+// regardless of their TTL. Unless SessionLocks is set, nodes also store the
+// expiration of the lock, after which they are ignored and deleted by the
+// other clients.
+// The driver is built with the zookeeper build tag.
+type ZookeeperClient struct {
+	opts ZookeeperOptions
+	id   string
+	conn *zk.Conn
+}
+
+// ZookeeperLock is the Lock implementation for zookeeper
+type ZookeeperLock struct {
+	name   string
+	ttl    time.Duration
+	client *ZookeeperClient
+	data   string
+	// node is the path of the node holding the lock, if acquired by this
+	// object
+	node string
+}
+
+// zookeeperValue is the data of the lock nodes
+type zookeeperValue struct {
+	Owner string `json:"owner"`
+	Data  string `json:"data"`
+	// Expires is 0 for session locks
+	Expires int64 `json:"expires"`
+}
+
+func (v *zookeeperValue) expired() bool {
+	return v.Expires != 0 && time.Now().UnixNano() >= v.Expires
+}
+
+// NewZookeeperClient creates a new client from options, connecting to
+// zookeeper
+func NewZookeeperClient(opts ZookeeperOptions) (*ZookeeperClient, error) {
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "/glock"
+	}
+	if opts.SessionTimeout <= 0 {
+		opts.SessionTimeout = 10 * time.Second
+	}
+	c := &ZookeeperClient{opts: opts, id: opts.ClientID}
+	if err := c.Reconnect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ID implements the Client interface
+func (c *ZookeeperClient) ID() string {
+	return c.id
+}
+
+// SetID implements the Client interface
+func (c *ZookeeperClient) SetID(id string) {
+	c.id = id
+}
+
+// Reconnect implements the Client interface.
+// Reconnecting starts a new session, releasing all the locks of the client.
+func (c *ZookeeperClient) Reconnect() error {
+	c.Close()
+	conn, _, err := zk.Connect(c.opts.Servers, c.opts.SessionTimeout)
+	if err != nil {
+		return backendError("zookeeper", err)
+	}
+	c.conn = conn
+	return nil
+}
+
+// Close implements the Client interface.
+// Closing the client ends its session, releasing all its locks.
+func (c *ZookeeperClient) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Clone implements the Client interface
+func (c *ZookeeperClient) Clone() Client {
+	return &ZookeeperClient{opts: c.opts, id: c.id}
+}
+
+// NewLock implements the Client interface
+func (c *ZookeeperClient) NewLock(name string) Lock {
+	return &ZookeeperLock{name: name, client: c}
+}
+
+// AcquireMulti implements the Client interface
+func (c *ZookeeperClient) AcquireMulti(names []string, ttl time.Duration) ([]Lock, error) {
+	return acquireMulti(c, names, ttl)
+}
+
+// ReleaseMulti implements the Client interface
+func (c *ZookeeperClient) ReleaseMulti(locks []Lock) error {
+	return releaseMulti(locks)
+}
+
+// createParents creates the persistent znode p and its parents, if missing
+func (c *ZookeeperClient) createParents(p string) error {
+	if p == "/" {
+		return nil
+	}
+	if err := c.createParents(path.Dir(p)); err != nil {
+		return err
+	}
+	_, err := c.conn.Create(p, nil, 0, zk.WorldACL(zk.PermAll))
+	if err == zk.ErrNodeExists {
+		return nil
+	}
+	return backendError("zookeeper", err)
+}
+
+func (l *ZookeeperLock) key() string {
+	return path.Join(l.client.opts.Namespace, l.name)
+}
+
+func (l *ZookeeperLock) value(ttl time.Duration) ([]byte, error) {
+	v := zookeeperValue{Owner: l.client.id, Data: l.data}
+	if !l.client.opts.SessionLocks {
+		v.Expires = time.Now().Add(ttl).UnixNano()
+	}
+	return json.Marshal(v)
+}
+
+// get returns the value of the node, nil if it doesn't exist
+func (l *ZookeeperLock) get(node string) (*zookeeperValue, *zk.Stat, error) {
+	data, stat, err := l.client.conn.Get(node)
+	if err == zk.ErrNoNode {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, backendError("zookeeper", err)
+	}
+	var v zookeeperValue
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, nil, err
+	}
+	return &v, stat, nil
+}
+
+// nodes returns the paths of the nodes of the lock, by sequence
+func (l *ZookeeperLock) nodes() ([]string, error) {
+	children, _, err := l.client.conn.Children(l.key())
+	if err == zk.ErrNoNode {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, backendError("zookeeper", err)
+	}
+	nodes := children[:0]
+	for _, child := range children {
+		if strings.HasPrefix(child, zookeeperNodePrefix) {
+			nodes = append(nodes, path.Join(l.key(), child))
+		}
+	}
+	// sequences have a fixed width, so they sort as strings
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// holder returns the node holding the lock and its value, deleting the
+// expired nodes preceding it. It stops at node, if not empty.
+func (l *ZookeeperLock) holder(node string) (string, *zookeeperValue, error) {
+	nodes, err := l.nodes()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, n := range nodes {
+		if n == node {
+			return "", nil, nil
+		}
+		v, stat, err := l.get(n)
+		if err != nil {
+			return "", nil, err
+		}
+		if v == nil {
+			continue
+		}
+		if !v.expired() {
+			return n, v, nil
+		}
+		err = l.client.conn.Delete(n, stat.Version)
+		if err != nil && err != zk.ErrNoNode && err != zk.ErrBadVersion {
+			return "", nil, backendError("zookeeper", err)
+		}
+	}
+	return "", nil, nil
+}
+
+// enqueue creates the node of this object for the lock
+func (l *ZookeeperLock) enqueue(ttl time.Duration) (string, error) {
+	value, err := l.value(ttl)
+	if err != nil {
+		return "", err
+	}
+	if err := l.client.createParents(l.key()); err != nil {
+		return "", err
+	}
+	node, err := l.client.conn.Create(path.Join(l.key(), zookeeperNodePrefix), value,
+		zk.FlagEphemeral|zk.FlagSequence, zk.WorldACL(zk.PermAll))
+	return node, backendError("zookeeper", err)
+}
+
+// Acquire acquires the lock for the specified time lentgh (ttl).
+// It returns immadiately if the lock cannot be acquired.
+func (l *ZookeeperLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext implements the Lock interface. The zookeeper client doesn't
+// support contexts, so ctx is only checked before acquiring the lock.
+func (l *ZookeeperLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	node, err := l.enqueue(ttl)
+	if err != nil {
+		return err
+	}
+	holder, _, err := l.holder(node)
+	if err != nil || holder != "" {
+		l.client.conn.Delete(node, -1)
+		if err != nil {
+			return err
+		}
+		return ErrLockHeldByOtherClient
+	}
+	l.node = node
+	return nil
+}
+
+// WaitAcquire implements the Lock interface. With the Fair option, the lock
+// is acquired in order by the waiting clients.
+func (l *ZookeeperLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	if !l.client.opts.Fair {
+		return waitAcquire(ctx, func() error { return l.AcquireContext(ctx, ttl) },
+			defaultWaitBackoff, defaultWaitMaxBackoff)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	node, err := l.enqueue(ttl)
+	if err != nil {
+		return err
+	}
+	if err = l.queue(ctx, node, ttl); err != nil {
+		l.client.conn.Delete(node, -1)
+		if err == ctx.Err() {
+			// still queued behind the holder of the lock
+			recordAttempt(ctx, ErrLockHeldByOtherClient)
+		}
+		return err
+	}
+	l.node = node
+	// the lock TTL starts when acquired
+	return l.RefreshContext(ctx)
+}
+
+// queue waits for node to be the first live node of the lock. Unless locks
+// are session locks, node is kept alive while waiting.
+func (l *ZookeeperLock) queue(ctx context.Context, node string, ttl time.Duration) error {
+	for {
+		holder, v, err := l.holder(node)
+		if err != nil || holder == "" {
+			return err
+		}
+		exists, _, events, err := l.client.conn.ExistsW(holder)
+		if err != nil {
+			return backendError("zookeeper", err)
+		}
+		if !exists {
+			continue
+		}
+		var wake <-chan time.Time
+		if v.Expires != 0 {
+			// the holder doesn't go away when its lock expires
+			delay := time.Duration(v.Expires - time.Now().UnixNano())
+			if delay > ttl/2 {
+				delay = ttl / 2
+			}
+			timer := time.NewTimer(delay)
+			wake = timer.C
+			select {
+			case <-events:
+			case <-wake:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+			timer.Stop()
+			if err := l.keepQueued(node, ttl); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case <-events:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// keepQueued extends the expiration of a node waiting for the lock
+func (l *ZookeeperLock) keepQueued(node string, ttl time.Duration) error {
+	value, err := l.value(ttl)
+	if err != nil {
+		return err
+	}
+	_, err = l.client.conn.Set(node, value, -1)
+	if err == zk.ErrNoNode {
+		return ErrLockNotOwned
+	}
+	return backendError("zookeeper", err)
+}
+
+// Release releases the lock if owned, deleting its node.
+// Returns an error if the lock is not owned by this client
+func (l *ZookeeperLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext implements the Lock interface
+func (l *ZookeeperLock) ReleaseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.node == "" {
+		holder, _, err := l.holder("")
+		if err != nil {
+			return err
+		}
+		if holder != "" {
+			return ErrLockNotOwned
+		}
+		return ErrLockExpired
+	}
+	v, stat, err := l.get(l.node)
+	if err != nil {
+		return err
+	}
+	node := l.node
+	l.node = ""
+	if v == nil {
+		return ErrLockExpired
+	}
+	err = l.client.conn.Delete(node, stat.Version)
+	if err == zk.ErrNoNode {
+		return ErrLockExpired
+	}
+	if err == zk.ErrBadVersion {
+		return ErrLockNotOwned
+	}
+	if err == nil && v.expired() {
+		return ErrLockExpired
+	}
+	return backendError("zookeeper", err)
+}
+
+// Info returns information about the lock.
+func (l *ZookeeperLock) Info() (*LockInfo, error) {
+	holder, v, err := l.holder("")
+	if err != nil {
+		return nil, err
+	}
+	if holder == "" {
+		return &LockInfo{Name: l.name, Acquired: false}, nil
+	}
+	info := &LockInfo{
+		Name:     l.name,
+		Acquired: true,
+		Owner:    v.Owner,
+		TTL:      NoExpiry,
+		Data:     v.Data,
+	}
+	if v.Expires != 0 {
+		info.TTL = time.Duration(v.Expires - time.Now().UnixNano())
+	}
+	return info, nil
+}
+
+// RemainingTTL returns the remaining TTL of the lock, 0 if not held and
+// NoExpiry for session locks.
+func (l *ZookeeperLock) RemainingTTL() (time.Duration, error) {
+	info, err := l.Info()
+	if err != nil || !info.Acquired {
+		return 0, err
+	}
+	return info.TTL, nil
+}
+
+// RefreshTTL Extends the lock, if owned, for the specified TTL.
+// ttl argument becomes the new ttl for the lock: successive calls to Refresh()
+// will use this ttl
+// It returns an error if the lock is not owned by the current client
+func (l *ZookeeperLock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.ttl = ttl
+	return l.Refresh()
+}
+
+// Refresh extends the lock, updating the expiration in its node.
+// It returns an error if the lock is not owned by the current client
+func (l *ZookeeperLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext implements the Lock interface
+func (l *ZookeeperLock) RefreshContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	if l.ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	if l.node == "" {
+		return ErrLockNotOwned
+	}
+	v, stat, err := l.get(l.node)
+	if err != nil {
+		return err
+	}
+	if v == nil || v.expired() {
+		return ErrLockNotOwned
+	}
+	value, err := l.value(l.ttl)
+	if err != nil {
+		return err
+	}
+	_, err = l.client.conn.Set(l.node, value, stat.Version)
+	if err == zk.ErrNoNode || err == zk.ErrBadVersion {
+		return ErrLockNotOwned
+	}
+	return backendError("zookeeper", err)
+}
+
+// SetData sets the data payload for the lock.
+// The data is set into the backend only when the lock is acquired,
+// so any call to this method after acquisition won't update the value.
+func (l *ZookeeperLock) SetData(data string) {
+	l.data = data
+}
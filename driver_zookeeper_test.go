@@ -0,0 +1,142 @@
+// +build zookeeper
+
+package glock
+
+import (
+	"context"
+	"flag"
+	"testing"
+	"time"
+)
+
+var zookeeperServer = flag.String("zookeeper-server", "127.0.0.1:2181", "Zookeeper server")
+
+func zookeeperOptions() ZookeeperOptions {
+	return ZookeeperOptions{
+		Servers:   []string{*zookeeperServer},
+		Namespace: "/glock_test",
+	}
+}
+
+func newZookeeperClient(t *testing.T, opts ZookeeperOptions) *ZookeeperClient {
+	c, err := NewZookeeperClient(opts)
+	if err != nil {
+		t.Fatalf("Cannot create zookeeper client: %s", err)
+	}
+	return c
+}
+
+func zookeeperClient(t *testing.T) Client {
+	return newZookeeperClient(t, zookeeperOptions())
+}
+
+func TestZookeeperClient(t *testing.T) {
+	testClient(t, zookeeperClient)
+}
+
+func TestZookeeperLock(t *testing.T) {
+	testLock(t, zookeeperClient, time.Second)
+}
+
+func TestZookeeperLockRemainingTTL(t *testing.T) {
+	testLockRemainingTTL(t, zookeeperClient, time.Second)
+}
+
+func TestZookeeperLockWaitAcquire(t *testing.T) {
+	testLockWaitAcquire(t, zookeeperClient, time.Second)
+}
+
+func TestZookeeperAcquireWait(t *testing.T) {
+	testAcquireWait(t, zookeeperClient, time.Second)
+}
+
+func TestZookeeperAcquireAndKeepAlive(t *testing.T) {
+	testAcquireAndKeepAlive(t, zookeeperClient, time.Second)
+}
+
+func TestZookeeperLockContext(t *testing.T) {
+	testLockContext(t, zookeeperClient, time.Second)
+}
+
+func TestZookeeperAcquireMulti(t *testing.T) {
+	testAcquireMulti(t, zookeeperClient, time.Second)
+}
+
+func TestZookeeperLockRefreshNotHeld(t *testing.T) {
+	testLockRefreshNotHeld(t, zookeeperClient, time.Second)
+}
+
+func TestZookeeperFairWaitAcquire(t *testing.T) {
+	opts := zookeeperOptions()
+	opts.Fair = true
+	c1 := newZookeeperClient(t, opts)
+	c2 := newZookeeperClient(t, opts)
+	c3 := newZookeeperClient(t, opts)
+	ttl := time.Duration(ttlLength) * time.Second
+
+	lock1 := c1.NewLock(lockName)
+	if err := lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ttl)
+	defer cancel()
+	acquired := make(chan string, 2)
+	wait := func(c Client) {
+		lock := c.NewLock(lockName)
+		if err := lock.WaitAcquire(ctx, ttl); err != nil {
+			t.Errorf("Cannot wait for lock '%s': %s", lockName, err)
+			acquired <- ""
+			return
+		}
+		acquired <- c.ID()
+		time.Sleep(100 * time.Millisecond)
+		lock.Release()
+	}
+	go wait(c2)
+	time.Sleep(time.Second)
+	// a new client doesn't jump the queue
+	if err := c3.NewLock(lockName).Acquire(ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+	go wait(c3)
+	time.Sleep(time.Second)
+
+	if err := lock1.Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+	for _, id := range []string{c2.ID(), c3.ID()} {
+		if got := <-acquired; got != id {
+			t.Errorf("Expected lock acquired by %s, got %s", id, got)
+		}
+	}
+}
+
+func TestZookeeperSessionLocks(t *testing.T) {
+	opts := zookeeperOptions()
+	opts.SessionLocks = true
+	c1 := newZookeeperClient(t, opts)
+	c2 := newZookeeperClient(t, opts)
+
+	lock1 := c1.NewLock(lockName)
+	if err := lock1.Acquire(time.Second); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	// the lock outlives its TTL
+	time.Sleep(2 * time.Second)
+	info, err := c2.NewLock(lockName).Info()
+	if err != nil || !info.Acquired || info.Owner != c1.ID() || info.TTL != NoExpiry {
+		t.Errorf("Expected lock held by %s with no expiry, got %+v %v", c1.ID(), info, err)
+	}
+	if err := lock1.Refresh(); err != nil {
+		t.Errorf("Cannot refresh lock '%s': %s", lockName, err)
+	}
+
+	// and is released with the session
+	c1.Close()
+	lock2 := c2.NewLock(lockName)
+	if err := lock2.Acquire(time.Second); err != nil {
+		t.Fatalf("Cannot acquire lock released with the session: %s", err)
+	}
+	lock2.Release()
+}
@@ -0,0 +1,80 @@
+package glock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+)
+
+var (
+	// ErrNotAcquired is matched with errors.Is by the errors of the
+	// acquisitions refused because of the other holders of the lock, that
+	// may succeed once they release it: ErrLockHeldByOtherClient,
+	// ErrReentrantDenied, ErrSemaphoreFull and ErrRateLimited
+	ErrNotAcquired = errors.New("Lock not acquired")
+	// ErrConnection is matched with errors.Is by the *OpError and
+	// *BackendError wrapping a transport error (as opposed to an error
+	// replied by the store): the operation may succeed once reconnected,
+	// see Reconnect
+	ErrConnection = errors.New("Connection to the store failed")
+)
+
+// classError is a sentinel error belonging to a class of errors, matched
+// with errors.Is, see ErrNotAcquired
+type classError struct {
+	msg   string
+	class error
+}
+
+func newClassError(msg string, class error) error {
+	return &classError{msg: msg, class: class}
+}
+
+func (e *classError) Error() string {
+	return e.msg
+}
+
+// Is tells if target is the class of e
+func (e *classError) Is(target error) bool {
+	return target == e.class
+}
+
+// BackendError wraps an error returned by the store to the cassandra,
+// consul, dynamodb, etcd, postgres and zookeeper drivers. The redis driver
+// returns an *OpError instead.
+type BackendError struct {
+	// Driver is the name of the driver, i.e. "etcd"
+	Driver string
+	// Cause is the error returned by the store client
+	Cause error
+}
+
+func (e *BackendError) Error() string {
+	return "glock: " + e.Driver + ": " + e.Cause.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *BackendError) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches ErrConnection if the underlying error is a network error
+func (e *BackendError) Is(target error) bool {
+	return target == ErrConnection && isNetError(e.Cause)
+}
+
+// backendError wraps err, returned by the store of driver, into a
+// BackendError. The errors of a done context are returned as is
+func backendError(driver string, err error) error {
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+	return &BackendError{Driver: driver, Cause: err}
+}
+
+// isNetError tells if err is a transport error
+func isNetError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
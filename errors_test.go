@@ -0,0 +1,126 @@
+package glock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestErrorClasses(t *testing.T) {
+	for _, err := range []error{ErrLockHeldByOtherClient, ErrReentrantDenied, ErrRateLimited, ErrSemaphoreFull} {
+		if !errors.Is(fmt.Errorf("wrapped: %w", err), ErrNotAcquired) {
+			t.Errorf("Expected '%s' to match ErrNotAcquired", err)
+		}
+		if errors.Is(err, ErrConnection) {
+			t.Errorf("Expected '%s' not to match ErrConnection", err)
+		}
+	}
+	for _, err := range []error{ErrLockNotOwned, ErrLockExpired, ErrInvalidTTL} {
+		if errors.Is(err, ErrNotAcquired) {
+			t.Errorf("Expected '%s' not to match ErrNotAcquired", err)
+		}
+	}
+}
+
+func TestBackendError(t *testing.T) {
+	if err := backendError("etcd", nil); err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	err := backendError("etcd", dialErr)
+	var berr *BackendError
+	if !errors.As(err, &berr) || berr.Driver != "etcd" || berr.Cause != dialErr {
+		t.Errorf("Expected a BackendError wrapping the dial error, got %#v", err)
+	}
+	if !errors.Is(err, ErrConnection) {
+		t.Errorf("Expected '%s' to match ErrConnection", err)
+	}
+	if !errors.Is(backendError("postgres", io.EOF), ErrConnection) {
+		t.Errorf("Expected EOF to match ErrConnection")
+	}
+	if err := backendError("postgres", errors.New("syntax error")); errors.Is(err, ErrConnection) {
+		t.Errorf("Expected '%s' not to match ErrConnection", err)
+	}
+}
+
+func TestOpErrorConnection(t *testing.T) {
+	if err := opError(EventAcquire, "EVALSHA", io.EOF); !errors.Is(err, ErrConnection) {
+		t.Errorf("Expected '%s' to match ErrConnection", err)
+	}
+	if err := opError(EventAcquire, "EVALSHA", redis.Error("ERR unknown command")); errors.Is(err, ErrConnection) {
+		t.Errorf("Expected '%s' not to match ErrConnection", err)
+	}
+}
+
+// TestSentinels checks that every exported error of the package is listed in
+// sentinels, so that it's restored as such when decoding events
+func TestSentinels(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("Cannot parse package: %s", err)
+	}
+	declared := make(map[string]bool)
+	listed := make(map[string]bool)
+	for _, f := range pkgs["glock"].Files {
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, name := range vs.Names {
+					if name.IsExported() && strings.HasPrefix(name.Name, "Err") {
+						declared[name.Name] = true
+					}
+					if name.Name != "sentinels" || i >= len(vs.Values) {
+						continue
+					}
+					for _, elt := range vs.Values[i].(*ast.CompositeLit).Elts {
+						listed[elt.(*ast.Ident).Name] = true
+					}
+				}
+			}
+		}
+	}
+	if len(declared) == 0 {
+		t.Fatalf("No error found in the package")
+	}
+	for name := range declared {
+		if !listed[name] {
+			t.Errorf("Expected %s to be listed in sentinels", name)
+		}
+	}
+
+	messages := make(map[string]bool)
+	for _, s := range sentinels {
+		if messages[s.Error()] {
+			t.Errorf("Expected a unique message, got '%s' twice", s)
+		}
+		messages[s.Error()] = true
+		data, err := json.Marshal(Event{Lock: "lock", Op: EventAcquire, Err: s})
+		if err != nil {
+			t.Fatalf("Cannot encode event: %s", err)
+		}
+		var e Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			t.Fatalf("Cannot decode event: %s", err)
+		}
+		if e.Err != s {
+			t.Errorf("Expected '%s' to be decoded as such, got %#v", s, e.Err)
+		}
+	}
+}
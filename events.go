@@ -0,0 +1,133 @@
+package glock
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Operations recorded in an Event
+const (
+	EventAcquire = "acquire"
+	EventRelease = "release"
+	EventRefresh = "refresh"
+	// EventLost is recorded when a refresh finds the lock not owned anymore
+	EventLost = "lost"
+	// EventExpire is notified by RedisClient.Watch when a lock expires
+	EventExpire = "expire"
+)
+
+// Event records the outcome of an operation on a lock
+type Event struct {
+	// Time is when the operation completed
+	Time time.Time
+	// Lock is the lock name
+	Lock string
+	// Op is one of the Event* operations
+	Op string
+	// Err is the error returned by the operation, if any
+	Err error
+}
+
+// EventSchemaVersion is the version of the JSON encoding of Event. It is
+// increased on incompatible changes only: fields may be added within a version.
+const EventSchemaVersion = 1
+
+// jsonEvent is the JSON encoding of Event, i.e.
+//
+//	{"v":1,"time":"2017-09-01T10:00:00.123456789Z","lock":"name","op":"acquire","error":"..."}
+//
+// error is omitted if the operation succeeded.
+type jsonEvent struct {
+	Version int       `json:"v"`
+	Time    time.Time `json:"time"`
+	Lock    string    `json:"lock"`
+	Op      string    `json:"op"`
+	Err     string    `json:"error,omitempty"`
+}
+
+// MarshalJSON encodes the event with the versioned schema described in
+// EventSchemaVersion
+func (e Event) MarshalJSON() ([]byte, error) {
+	je := jsonEvent{Version: EventSchemaVersion, Time: e.Time, Lock: e.Lock, Op: e.Op}
+	if e.Err != nil {
+		je.Err = e.Err.Error()
+	}
+	return json.Marshal(je)
+}
+
+// UnmarshalJSON decodes an event encoded by MarshalJSON. The package errors
+// are restored as such, so that they can be compared with ==, other errors
+// only keep their message.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var je jsonEvent
+	if err := json.Unmarshal(data, &je); err != nil {
+		return err
+	}
+	if je.Version != EventSchemaVersion {
+		return ErrUnknownEventVersion
+	}
+	*e = Event{Time: je.Time, Lock: je.Lock, Op: je.Op}
+	if je.Err == "" {
+		return nil
+	}
+	e.Err = sentinelError(je.Err)
+	return nil
+}
+
+// sentinelError returns the package error with the given message, if any, a
+// new error with the message otherwise
+func sentinelError(msg string) error {
+	for _, s := range sentinels {
+		if s.Error() == msg {
+			return s
+		}
+	}
+	return errors.New(msg)
+}
+
+// eventRing keeps the last N events, overwriting the oldest ones
+type eventRing struct {
+	// seen counts the events for sampling, accessed atomically
+	seen   uint64
+	mtx    sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+func newEventRing(size int) *eventRing {
+	return &eventRing{events: make([]Event, size)}
+}
+
+func (r *eventRing) add(e Event) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// sample tells if the next event must be recorded, keeping one in rate events
+func (r *eventRing) sample(rate int) bool {
+	if rate <= 1 {
+		return true
+	}
+	return atomic.AddUint64(&r.seen, 1)%uint64(rate) == 0
+}
+
+// list returns a copy of the recorded events, oldest first
+func (r *eventRing) list() []Event {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !r.full {
+		return append([]Event(nil), r.events[:r.next]...)
+	}
+	res := make([]Event, 0, len(r.events))
+	res = append(res, r.events[r.next:]...)
+	return append(res, r.events[:r.next]...)
+}
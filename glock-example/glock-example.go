@@ -38,11 +38,20 @@ func main() {
 
 	switch *tp {
 	case "cassandra":
-		opts := glock.CassandraOptions{[]string{"localhost"}, "test", "", "", "test", 1}
+		opts := glock.CassandraOptions{
+			Hosts:             []string{"localhost"},
+			KeySpace:          "test",
+			TableName:         "test",
+			ReplicationFactor: 1,
+		}
 		c, err = glock.NewCassandraLockClient(opts)
 		c2, err = glock.NewCassandraLockClient(opts)
 	case "redis":
-		opts := glock.RedisOptions{"tcp", "localhost:6379", "", "myns", nil, nil}
+		opts := glock.RedisOptions{
+			Network:   "tcp",
+			Address:   "localhost:6379",
+			Namespace: "myns",
+		}
 		c, err = glock.NewRedisClient(opts)
 		c2, err = glock.NewRedisClient(opts)
 	case "memory":
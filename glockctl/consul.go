@@ -0,0 +1,24 @@
+// +build consul
+
+package main
+
+import (
+	"flag"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+var consulAddress = flag.String("consul-address", "", "consul agent address. Defaults to the consul default")
+var consulToken = flag.String("consul-token", "", "consul ACL token")
+var consulNS = flag.String("consul-namespace", "", "prefix of the consul keys. Defaults to the glock default")
+
+func init() {
+	drivers["consul"] = func() (glock.Client, error) {
+		return glock.NewConsulClient(glock.ConsulOptions{
+			Address:   *consulAddress,
+			Token:     *consulToken,
+			ClientID:  *id,
+			Namespace: *consulNS,
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// +build dynamodb
+
+package main
+
+import (
+	"flag"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+var dynamoRegion = flag.String("dynamodb-region", "", "AWS region. Defaults to the AWS SDK default")
+var dynamoEndpoint = flag.String("dynamodb-endpoint", "", "dynamodb endpoint, i.e. of dynamodb local")
+var dynamoTable = flag.String("dynamodb-table", "", "table of the locks. Defaults to the glock default")
+
+func init() {
+	drivers["dynamodb"] = func() (glock.Client, error) {
+		config := &aws.Config{}
+		if *dynamoRegion != "" {
+			config.Region = aws.String(*dynamoRegion)
+		}
+		if *dynamoEndpoint != "" {
+			config.Endpoint = aws.String(*dynamoEndpoint)
+		}
+		return glock.NewDynamoDBClient(glock.DynamoDBOptions{
+			Config:    config,
+			TableName: *dynamoTable,
+			ClientID:  *id,
+		})
+	}
+}
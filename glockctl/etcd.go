@@ -0,0 +1,23 @@
+// +build etcd
+
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+var etcdEndpoints = flag.String("etcd-endpoints", "localhost:2379", "comma separated list of etcd endpoints")
+var etcdNS = flag.String("etcd-namespace", "", "prefix of the etcd keys. Defaults to the glock default")
+
+func init() {
+	drivers["etcd"] = func() (glock.Client, error) {
+		return glock.NewEtcdClient(glock.EtcdOptions{
+			Endpoints: strings.Split(*etcdEndpoints, ","),
+			ClientID:  *id,
+			Namespace: *etcdNS,
+		})
+	}
+}
@@ -0,0 +1,23 @@
+// +build postgres
+
+package main
+
+import (
+	"flag"
+
+	_ "github.com/lib/pq"
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+var pgDataSource = flag.String("postgres-dsn", "postgres://localhost/glock?sslmode=disable", "postgres data source name")
+var pgTable = flag.String("postgres-table", "", "table of the locks. Defaults to the glock default")
+
+func init() {
+	drivers["postgres"] = func() (glock.Client, error) {
+		return glock.NewPostgresClient(glock.PostgresOptions{
+			DataSource: *pgDataSource,
+			TableName:  *pgTable,
+			ClientID:   *id,
+		})
+	}
+}
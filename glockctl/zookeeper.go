@@ -0,0 +1,23 @@
+// +build zookeeper
+
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+var zkServers = flag.String("zookeeper-servers", "localhost:2181", "comma separated list of zookeeper servers")
+var zkNS = flag.String("zookeeper-namespace", "", "path of the parent znode of the locks. Defaults to the glock default")
+
+func init() {
+	drivers["zookeeper"] = func() (glock.Client, error) {
+		return glock.NewZookeeperClient(glock.ZookeeperOptions{
+			Servers:   strings.Split(*zkServers, ","),
+			ClientID:  *id,
+			Namespace: *zkNS,
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Package glockotel records the lock operations of glock clients as
+// OpenTelemetry spans.
+//
+//	m := glockotel.NewMetrics(otel.Tracer("glock"), glockprom.NewMetrics("myapp"))
+//	client, err := glock.NewRedisClient(glock.RedisOptions{Metrics: m, ...})
+package glockotel
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// Metrics implements glock.Metrics, starting a span for each acquire,
+// release and refresh and for each redis command, once they completed
+// (the spans are backdated to their start). The operations don't take a
+// context, so the spans are roots of their own traces.
+type Metrics struct {
+	tracer trace.Tracer
+	next   glock.Metrics
+}
+
+// NewMetrics returns Metrics recording spans with tracer, and passing the
+// measurements on to next, if not nil
+func NewMetrics(tracer trace.Tracer, next glock.Metrics) *Metrics {
+	return &Metrics{tracer: tracer, next: next}
+}
+
+// span records a span of dur ending now
+func (m *Metrics) span(name string, dur time.Duration, err error, attrs ...attribute.KeyValue) {
+	end := time.Now()
+	_, span := m.tracer.Start(context.Background(), name, trace.WithTimestamp(end.Add(-dur)),
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
+
+// Acquired implements glock.Metrics
+func (m *Metrics) Acquired(name string, dur time.Duration, err error) {
+	m.span("glock.acquire", dur, err, attribute.String("glock.lock", name),
+		attribute.Bool("glock.contended", err == glock.ErrLockHeldByOtherClient))
+	if m.next != nil {
+		m.next.Acquired(name, dur, err)
+	}
+}
+
+// Released implements glock.Metrics
+func (m *Metrics) Released(name string, dur time.Duration, err error) {
+	m.span("glock.release", dur, err, attribute.String("glock.lock", name))
+	if m.next != nil {
+		m.next.Released(name, dur, err)
+	}
+}
+
+// Refreshed implements glock.Metrics
+func (m *Metrics) Refreshed(name string, dur time.Duration, err error) {
+	m.span("glock.refresh", dur, err, attribute.String("glock.lock", name))
+	if m.next != nil {
+		m.next.Refreshed(name, dur, err)
+	}
+}
+
+// Held implements glock.Metrics. It records no span.
+func (m *Metrics) Held(name string, held time.Duration) {
+	if m.next != nil {
+		m.next.Held(name, held)
+	}
+}
+
+// Command implements glock.Metrics
+func (m *Metrics) Command(cmd string, dur time.Duration, err error) {
+	m.span("redis "+cmd, dur, err, attribute.String("db.system", "redis"),
+		attribute.String("db.operation", cmd))
+	if m.next != nil {
+		m.next.Command(cmd, dur, err)
+	}
+}
@@ -0,0 +1,123 @@
+// Package glockprom exports the metrics of glock clients to Prometheus.
+//
+//	m := glockprom.NewMetrics("myapp")
+//	prometheus.MustRegister(m)
+//	client, err := glock.NewRedisClient(glock.RedisOptions{Metrics: m, ...})
+package glockprom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// Metrics implements glock.Metrics with Prometheus collectors, labeled by
+// lock name (or redis command). Lock names should be few: each creates new
+// time series.
+type Metrics struct {
+	attempts  *prometheus.CounterVec
+	acquired  *prometheus.CounterVec
+	failures  *prometheus.CounterVec
+	contended *prometheus.CounterVec
+	releases  *prometheus.CounterVec
+	refreshes *prometheus.CounterVec
+	lost      *prometheus.CounterVec
+	held      *prometheus.HistogramVec
+	commands  *prometheus.HistogramVec
+}
+
+// NewMetrics returns the collectors, named <namespace>_glock_<metric>. They
+// must be registered, see prometheus.Register
+func NewMetrics(namespace string) *Metrics {
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "glock",
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+	histogram := func(name, help string, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "glock",
+			Name:      name,
+			Help:      help,
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 12),
+		}, labels)
+	}
+	return &Metrics{
+		attempts:  counter("acquire_attempts_total", "Number of acquisition attempts.", "lock"),
+		acquired:  counter("acquires_total", "Number of locks acquired.", "lock"),
+		failures:  counter("acquire_failures_total", "Number of acquisitions failed, including contended ones.", "lock"),
+		contended: counter("contended_total", "Number of acquisitions failed as the lock was held by another client.", "lock"),
+		releases:  counter("releases_total", "Number of releases, by outcome.", "lock", "result"),
+		refreshes: counter("refreshes_total", "Number of refreshes, by outcome.", "lock", "result"),
+		lost:      counter("lost_total", "Number of refreshes finding the lock not owned anymore.", "lock"),
+		held:      histogram("hold_duration_seconds", "How long the locks were held, until released.", "lock"),
+		commands:  histogram("command_duration_seconds", "Latency of the redis commands, by outcome.", "command", "result"),
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Acquired implements glock.Metrics
+func (m *Metrics) Acquired(name string, dur time.Duration, err error) {
+	m.attempts.WithLabelValues(name).Inc()
+	switch err {
+	case nil:
+		m.acquired.WithLabelValues(name).Inc()
+		return
+	case glock.ErrLockHeldByOtherClient:
+		m.contended.WithLabelValues(name).Inc()
+	}
+	m.failures.WithLabelValues(name).Inc()
+}
+
+// Released implements glock.Metrics
+func (m *Metrics) Released(name string, dur time.Duration, err error) {
+	m.releases.WithLabelValues(name, result(err)).Inc()
+}
+
+// Refreshed implements glock.Metrics
+func (m *Metrics) Refreshed(name string, dur time.Duration, err error) {
+	m.refreshes.WithLabelValues(name, result(err)).Inc()
+	if err == glock.ErrLockNotOwned {
+		m.lost.WithLabelValues(name).Inc()
+	}
+}
+
+// Held implements glock.Metrics
+func (m *Metrics) Held(name string, held time.Duration) {
+	m.held.WithLabelValues(name).Observe(held.Seconds())
+}
+
+// Command implements glock.Metrics
+func (m *Metrics) Command(cmd string, dur time.Duration, err error) {
+	m.commands.WithLabelValues(cmd, result(err)).Observe(dur.Seconds())
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.attempts, m.acquired, m.failures, m.contended, m.releases, m.refreshes,
+		m.lost, m.held, m.commands}
+}
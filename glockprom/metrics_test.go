@@ -0,0 +1,47 @@
+package glockprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+var _ glock.Metrics = (*Metrics)(nil)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics("test")
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatalf("Cannot register metrics: %s", err)
+	}
+
+	m.Acquired("a", time.Millisecond, nil)
+	m.Acquired("a", time.Millisecond, glock.ErrLockHeldByOtherClient)
+	m.Refreshed("a", time.Millisecond, glock.ErrLockNotOwned)
+	m.Released("a", time.Millisecond, nil)
+	m.Held("a", time.Second)
+	m.Command("EVALSHA", time.Millisecond, nil)
+
+	for _, tc := range []struct {
+		c        prometheus.Collector
+		expected float64
+	}{
+		{m.attempts.WithLabelValues("a"), 2},
+		{m.acquired.WithLabelValues("a"), 1},
+		{m.failures.WithLabelValues("a"), 1},
+		{m.contended.WithLabelValues("a"), 1},
+		{m.refreshes.WithLabelValues("a", "error"), 1},
+		{m.lost.WithLabelValues("a"), 1},
+		{m.releases.WithLabelValues("a", "ok"), 1},
+	} {
+		if got := testutil.ToFloat64(tc.c); got != tc.expected {
+			t.Errorf("Expected %v, got %v", tc.expected, got)
+		}
+	}
+	if n := testutil.CollectAndCount(m); n != 9 {
+		t.Errorf("Expected 9 metrics, got %d", n)
+	}
+}
@@ -0,0 +1,179 @@
+// Package glockserver exposes the locks of a glock.Client over HTTP, so that
+// services not written in Go share the same locks, with the same semantics.
+// glock.RemoteClient is the Go client of the service.
+//
+// The operations are posted as JSON (see glock.RemoteRequest) to /v1/acquire,
+// /v1/refresh, /v1/release, /v1/info and /v1/ttl, i.e.
+//
+//	curl -d '{"name": "jobs:nightly", "owner": "worker-1", "ttl_ms": 30000}' http://localhost:8080/v1/acquire
+//
+// and replied with a glock.RemoteResponse, its Error set if the operation
+// failed: with status 409 if the lock is not held by the owner (i.e.
+// glock.ErrLockHeldByOtherClient), 400 if the request is invalid, 500 if the
+// store failed.
+package glockserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// maxRequestSize bounds the size of the request bodies
+const maxRequestSize = 1 << 20
+
+// Options represents the options of a Server
+type Options struct {
+	// IdleTimeout is how long the client of an owner is kept after its last
+	// operation, see Server. Defaults to 5 minutes
+	IdleTimeout time.Duration
+}
+
+// Server is the http.Handler of the lock service. The operations of each
+// owner are done by a clone of the client of the server with the ID of the
+// owner, kept connected until idle for Options.IdleTimeout.
+type Server struct {
+	client glock.Client
+	opts   Options
+
+	mtx    sync.Mutex
+	owners map[string]*ownerClient
+}
+
+// ownerClient is the client doing the operations of an owner
+type ownerClient struct {
+	client glock.Client
+	used   time.Time
+}
+
+// NewServer returns a Server exposing the locks of client
+func NewServer(client glock.Client, opts Options) *Server {
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 5 * time.Minute
+	}
+	return &Server{client: client, opts: opts, owners: make(map[string]*ownerClient)}
+}
+
+// Close closes the clients of the owners. The client of the server is not
+// closed.
+func (s *Server) Close() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for id, o := range s.owners {
+		o.client.Close()
+		delete(s.owners, id)
+	}
+}
+
+// clientOf returns the client of the owner with the given ID, closing the
+// clients idle for longer than IdleTimeout
+func (s *Server) clientOf(id string) (glock.Client, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := time.Now()
+	for owner, o := range s.owners {
+		if owner != id && now.Sub(o.used) > s.opts.IdleTimeout {
+			o.client.Close()
+			delete(s.owners, owner)
+		}
+	}
+	o, ok := s.owners[id]
+	if !ok {
+		c := s.client.Clone()
+		c.SetID(id)
+		if err := c.Reconnect(); err != nil {
+			return nil, err
+		}
+		o = &ownerClient{client: c}
+		s.owners[id] = o
+	}
+	o.used = now
+	return o.client, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		reply(w, http.StatusMethodNotAllowed, nil, errors.New("Method not allowed"))
+		return
+	}
+	op := strings.TrimPrefix(r.URL.Path, "/v1/")
+	var req glock.RemoteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		reply(w, http.StatusBadRequest, nil, err)
+		return
+	}
+	if req.Name == "" {
+		reply(w, http.StatusBadRequest, nil, glock.ErrInvalidLock)
+		return
+	}
+
+	var info *glock.LockInfo
+	var err error
+	switch op {
+	case "info":
+		info, err = s.client.NewLock(req.Name).Info()
+	case "ttl":
+		var ttl time.Duration
+		ttl, err = s.client.NewLock(req.Name).RemainingTTL()
+		info = &glock.LockInfo{Name: req.Name, Acquired: ttl != 0, TTL: ttl}
+	case "acquire", "refresh", "release":
+		if req.Owner == "" {
+			reply(w, http.StatusBadRequest, nil, errors.New("Owner is required"))
+			return
+		}
+		var c glock.Client
+		if c, err = s.clientOf(req.Owner); err != nil {
+			break
+		}
+		lock := c.NewLock(req.Name)
+		lock.SetData(req.Data)
+		ttl := time.Duration(req.TTL) * time.Millisecond
+		switch op {
+		case "acquire":
+			err = lock.AcquireContext(r.Context(), ttl)
+		case "refresh":
+			err = lock.RefreshTTL(ttl)
+		default:
+			err = lock.ReleaseContext(r.Context())
+		}
+	default:
+		reply(w, http.StatusNotFound, nil, errors.New("Unknown operation "+op))
+		return
+	}
+	reply(w, status(err), info, err)
+}
+
+// status returns the HTTP status of the result of an operation
+func status(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, glock.ErrNotAcquired), err == glock.ErrLockNotOwned, err == glock.ErrLockExpired,
+		err == glock.ErrLockNotHeld, err == glock.ErrLockPersistent:
+		return http.StatusConflict
+	case err == glock.ErrInvalidTTL, err == glock.ErrInvalidLock, err == glock.ErrInvalidLockName,
+		err == glock.ErrDataTooLarge, err == glock.ErrDataKeyDisabled:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func reply(w http.ResponseWriter, status int, info *glock.LockInfo, err error) {
+	var resp glock.RemoteResponse
+	if info != nil && err == nil {
+		resp.Info = glock.NewRemoteInfo(info)
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,98 @@
+package glockserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+	"gopkg.in/gbagnoli/glock.v1/glocktest"
+)
+
+var clientSeq int64
+
+func TestRemoteConformance(t *testing.T) {
+	s := NewServer(glock.NewMemoryClient("server"), Options{})
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	glocktest.Conformance{
+		NewClient: func(t *testing.T) glock.Client {
+			c, err := glock.NewRemoteClient(glock.RemoteOptions{
+				URL:      ts.URL,
+				ClientID: "remote-" + strconv.FormatInt(atomic.AddInt64(&clientSeq, 1), 10),
+			})
+			if err != nil {
+				t.Fatalf("Cannot create remote client: %s", err)
+			}
+			return c
+		},
+		Scale:  50 * time.Millisecond,
+		Prefix: "glockserver-",
+	}.Run(t)
+}
+
+func TestServerHTTP(t *testing.T) {
+	s := NewServer(glock.NewMemoryClient("server"), Options{})
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	post := func(op, body string) (int, glock.RemoteResponse) {
+		resp, err := http.Post(ts.URL+"/v1/"+op, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Cannot post %s: %s", op, err)
+		}
+		defer resp.Body.Close()
+		var r glock.RemoteResponse
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			t.Fatalf("Cannot decode the response of %s: %s", op, err)
+		}
+		return resp.StatusCode, r
+	}
+
+	if code, r := post("acquire", `{"name": "http-lock", "owner": "one", "ttl_ms": 60000, "data": "payload"}`); code != http.StatusOK || r.Error != "" {
+		t.Fatalf("Cannot acquire lock: %d %s", code, r.Error)
+	}
+	if code, r := post("acquire", `{"name": "http-lock", "owner": "two", "ttl_ms": 60000}`); code != http.StatusConflict ||
+		r.Error != glock.ErrLockHeldByOtherClient.Error() {
+		t.Errorf("Expected 409 ErrLockHeldByOtherClient, got %d %s", code, r.Error)
+	}
+	code, r := post("info", `{"name": "http-lock"}`)
+	if code != http.StatusOK || r.Info == nil || !r.Info.Acquired || r.Info.Owner != "one" || r.Info.Data != "payload" ||
+		r.Info.TTL <= 0 || r.Info.TTL > 60000 {
+		t.Errorf("Expected the lock held by one, got %d %+v", code, r.Info)
+	}
+	if code, r := post("ttl", `{"name": "http-lock"}`); code != http.StatusOK || r.Info == nil || r.Info.TTL <= 0 {
+		t.Errorf("Expected the remaining TTL of the lock, got %d %+v", code, r.Info)
+	}
+	if code, _ := post("acquire", `{"name": "http-lock", "ttl_ms": 60000}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without owner, got %d", code)
+	}
+	if code, _ := post("acquire", `{"name": "other-lock", "owner": "one"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without TTL, got %d", code)
+	}
+	if code, _ := post("unknown", `{"name": "http-lock"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown operation, got %d", code)
+	}
+	if code, r := post("release", `{"name": "http-lock", "owner": "one"}`); code != http.StatusOK || r.Error != "" {
+		t.Errorf("Cannot release lock: %d %s", code, r.Error)
+	}
+	if code, r := post("release", `{"name": "http-lock", "owner": "one"}`); code != http.StatusConflict ||
+		r.Error != glock.ErrLockExpired.Error() {
+		t.Errorf("Expected 409 ErrLockExpired, got %d %s", code, r.Error)
+	}
+
+	resp, err := http.Get(ts.URL + "/v1/info")
+	if err != nil {
+		t.Fatalf("Cannot get info: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", resp.StatusCode)
+	}
+}
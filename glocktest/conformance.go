@@ -0,0 +1,352 @@
+package glocktest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// Conformance checks that a glock.Client implementation honors the contract
+// of the interface, i.e.
+//
+//	func TestConformance(t *testing.T) {
+//		glocktest.Conformance{NewClient: newClient}.Run(t)
+//	}
+type Conformance struct {
+	// NewClient returns a new connected client, with an ID unique to the
+	// test run, sharing the locks with the other clients
+	NewClient func(t *testing.T) glock.Client
+	// Scale is the TTL unit: the tests use TTLs of a few units, so it must
+	// be larger than the time resolution of the store and than the latency
+	// of its operations. Defaults to 100ms
+	Scale time.Duration
+	// Sleep waits until d elapsed on the clock of the store, i.e.
+	// Store.Advance. Defaults to time.Sleep
+	Sleep func(d time.Duration)
+	// Prefix is prepended to the names of the locks used. Defaults to
+	// "glocktest-"
+	Prefix string
+}
+
+// Run runs the conformance tests as subtests of t
+func (c Conformance) Run(t *testing.T) {
+	if c.Scale <= 0 {
+		c.Scale = 100 * time.Millisecond
+	}
+	if c.Sleep == nil {
+		c.Sleep = time.Sleep
+	}
+	if c.Prefix == "" {
+		c.Prefix = "glocktest-"
+	}
+	for _, test := range []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"Client", c.testClient},
+		{"Lock", c.testLock},
+		{"Expiry", c.testExpiry},
+		{"RefreshNotHeld", c.testRefreshNotHeld},
+		{"RemainingTTL", c.testRemainingTTL},
+		{"WaitAcquire", c.testWaitAcquire},
+		{"AcquireMulti", c.testAcquireMulti},
+		{"Context", c.testContext},
+	} {
+		t.Run(test.name, test.run)
+	}
+}
+
+func (c Conformance) lockName(t *testing.T) string {
+	return c.Prefix + t.Name()
+}
+
+func (c Conformance) testClient(t *testing.T) {
+	c1 := c.NewClient(t)
+	c2 := c.NewClient(t)
+	defer c1.Close()
+	defer c2.Close()
+	if c1.ID() == c2.ID() {
+		t.Errorf("Both clients have the same id: %s", c1.ID())
+	}
+
+	c1.SetID(c2.ID() + "-set")
+	if id := c1.ID(); id != c2.ID()+"-set" {
+		t.Errorf("SetID did not set the ID: expected %s, got %s", c2.ID()+"-set", id)
+	}
+	c3 := c1.Clone()
+	if c3.ID() != c1.ID() {
+		t.Errorf("Clone should copy the client ID: expected %s, got %s", c1.ID(), c3.ID())
+	}
+	c3.Close()
+
+	// Close and Reconnect are idempotent
+	c1.Close()
+	c1.Close()
+	if err := c1.Reconnect(); err != nil {
+		t.Fatalf("Reconnect error: %s", err)
+	}
+	if err := c1.Reconnect(); err != nil {
+		t.Fatalf("Reconnect error: %s", err)
+	}
+}
+
+func (c Conformance) testLock(t *testing.T) {
+	c1 := c.NewClient(t)
+	c2 := c.NewClient(t)
+	defer c1.Close()
+	defer c2.Close()
+	name := c.lockName(t)
+	ttl := 10 * c.Scale
+
+	lock1 := c1.NewLock(name)
+	lock2 := c2.NewLock(name)
+	lock1.SetData("client1")
+	if err := lock1.Acquire(0); err != glock.ErrInvalidTTL {
+		t.Errorf("Expected ErrInvalidTTL, got %v", err)
+	}
+	if err := lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", name, err)
+	}
+	if err := lock1.Acquire(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Acquiring a held lock again: expected ErrLockHeldByOtherClient, got %v", err)
+	}
+	if err := lock2.Acquire(ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+	if err := lock2.Release(); err != glock.ErrLockNotOwned {
+		t.Errorf("Releasing a lock held by another client: expected ErrLockNotOwned, got %v", err)
+	}
+	if err := lock2.RefreshTTL(ttl); err != glock.ErrLockNotOwned {
+		t.Errorf("Refreshing a lock held by another client: expected ErrLockNotOwned, got %v", err)
+	}
+	if err := lock1.Refresh(); err != nil {
+		t.Errorf("Cannot refresh lock '%s': %s", name, err)
+	}
+
+	for _, lock := range []glock.Lock{lock1, lock2} {
+		info, err := lock.Info()
+		if err != nil {
+			t.Fatalf("Error in Info: %s", err)
+		}
+		if info.Name != name || !info.Acquired || info.Owner != c1.ID() || info.Data != "client1" {
+			t.Errorf("Expected lock '%s' held by %s with data client1, got %+v", name, c1.ID(), info)
+		}
+		if info.TTL <= 0 || info.TTL > ttl {
+			t.Errorf("Expected TTL in (0, %v], got %v", ttl, info.TTL)
+		}
+	}
+
+	if err := lock1.Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", name, err)
+	}
+	if err := lock1.Release(); err != glock.ErrLockExpired {
+		t.Errorf("Releasing a released lock: expected ErrLockExpired, got %v", err)
+	}
+	info, err := lock2.Info()
+	if err != nil || info.Name != name || info.Acquired {
+		t.Errorf("Expected lock '%s' not acquired, got %+v %v", name, info, err)
+	}
+	if err := lock2.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire released lock '%s': %s", name, err)
+	}
+	if err := lock2.Release(); err != nil {
+		t.Errorf("Cannot release lock '%s': %s", name, err)
+	}
+}
+
+func (c Conformance) testExpiry(t *testing.T) {
+	c1 := c.NewClient(t)
+	c2 := c.NewClient(t)
+	defer c1.Close()
+	defer c2.Close()
+	name := c.lockName(t)
+
+	lock1 := c1.NewLock(name)
+	if err := lock1.Acquire(2 * c.Scale); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", name, err)
+	}
+	c.Sleep(3 * c.Scale)
+	if err := lock1.Refresh(); err != glock.ErrLockNotOwned {
+		t.Errorf("Refreshing an expired lock: expected ErrLockNotOwned, got %v", err)
+	}
+	if err := lock1.Release(); err != glock.ErrLockExpired {
+		t.Errorf("Releasing an expired lock: expected ErrLockExpired, got %v", err)
+	}
+
+	if err := lock1.Acquire(2 * c.Scale); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", name, err)
+	}
+	c.Sleep(3 * c.Scale)
+	lock2 := c2.NewLock(name)
+	if err := lock2.Acquire(10 * c.Scale); err != nil {
+		t.Fatalf("Cannot acquire expired lock '%s': %s", name, err)
+	}
+	if err := lock1.Release(); err != glock.ErrLockNotOwned {
+		t.Errorf("Releasing a lock taken by another client: expected ErrLockNotOwned, got %v", err)
+	}
+	if err := lock2.Release(); err != nil {
+		t.Errorf("Cannot release lock '%s': %s", name, err)
+	}
+}
+
+func (c Conformance) testRefreshNotHeld(t *testing.T) {
+	cl := c.NewClient(t)
+	defer cl.Close()
+	name := c.lockName(t)
+	lock := cl.NewLock(name)
+
+	if err := lock.Refresh(); err != glock.ErrLockNotHeld {
+		t.Errorf("Refreshing a lock never acquired: expected ErrLockNotHeld, got %v", err)
+	}
+	if err := lock.RefreshTTL(0); err != glock.ErrInvalidTTL {
+		t.Errorf("Expected ErrInvalidTTL, got %v", err)
+	}
+	if err := lock.Acquire(10 * c.Scale); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", name, err)
+	}
+	if err := lock.RefreshTTL(0); err != glock.ErrInvalidTTL {
+		t.Errorf("Expected ErrInvalidTTL, got %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Errorf("Cannot release lock '%s': %s", name, err)
+	}
+}
+
+func (c Conformance) testRemainingTTL(t *testing.T) {
+	cl := c.NewClient(t)
+	defer cl.Close()
+	name := c.lockName(t)
+	lock := cl.NewLock(name)
+	ttl := 10 * c.Scale
+
+	if remaining, err := lock.RemainingTTL(); err != nil || remaining != 0 {
+		t.Errorf("Expected no remaining TTL for a lock not held, got %v %v", remaining, err)
+	}
+	if err := lock.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", name, err)
+	}
+	if remaining, err := lock.RemainingTTL(); err != nil || remaining <= 0 || remaining > ttl {
+		t.Errorf("Expected remaining TTL in (0, %v], got %v %v", ttl, remaining, err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", name, err)
+	}
+	if remaining, err := lock.RemainingTTL(); err != nil || remaining != 0 {
+		t.Errorf("Expected no remaining TTL for a released lock, got %v %v", remaining, err)
+	}
+}
+
+func (c Conformance) testWaitAcquire(t *testing.T) {
+	c1 := c.NewClient(t)
+	c2 := c.NewClient(t)
+	defer c1.Close()
+	defer c2.Close()
+	name := c.lockName(t)
+	ttl := 10 * c.Scale
+
+	lock1 := c1.NewLock(name)
+	lock2 := c2.NewLock(name)
+	lock2.SetData("client2")
+	if err := lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", name, err)
+	}
+
+	// the wait is bounded by the context, in real time
+	ctx, cancel := context.WithTimeout(context.Background(), c.Scale)
+	defer cancel()
+	if err := lock2.WaitAcquire(ctx, ttl); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(c.Scale)
+		lock1.Release()
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*c.Scale)
+	defer cancel()
+	if err := lock2.WaitAcquire(ctx, ttl); err != nil {
+		t.Fatalf("Cannot wait for lock '%s': %s", name, err)
+	}
+	info, err := lock2.Info()
+	if err != nil || info.Owner != c2.ID() || info.Data != "client2" {
+		t.Errorf("Expected lock owned by %s with data client2, got %+v %v", c2.ID(), info, err)
+	}
+	if err := lock2.Release(); err != nil {
+		t.Errorf("Cannot release lock '%s': %s", name, err)
+	}
+}
+
+func (c Conformance) testAcquireMulti(t *testing.T) {
+	c1 := c.NewClient(t)
+	c2 := c.NewClient(t)
+	defer c1.Close()
+	defer c2.Close()
+	name := c.lockName(t)
+	ttl := 10 * c.Scale
+
+	locks, err := c1.AcquireMulti([]string{name + "-c", name + "-a", name + "-b", name + "-a"}, ttl)
+	if err != nil {
+		t.Fatalf("Cannot acquire locks: %s", err)
+	}
+	if len(locks) != 3 {
+		t.Fatalf("Expected 3 locks, got %d", len(locks))
+	}
+	for i, n := range []string{name + "-a", name + "-b", name + "-c"} {
+		info, err := locks[i].Info()
+		if err != nil || info.Name != n || info.Owner != c1.ID() {
+			t.Errorf("Expected lock %s held by %s, got %+v %v", n, c1.ID(), info, err)
+		}
+	}
+
+	// a conflict leaves no lock acquired
+	if _, err := c2.AcquireMulti([]string{name + "-0", name + "-b"}, ttl); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+	if info, err := c2.NewLock(name + "-0").Info(); err != nil || info.Acquired {
+		t.Errorf("Expected partially acquired locks to be released, got %+v %v", info, err)
+	}
+
+	if err := c1.ReleaseMulti(locks); err != nil {
+		t.Fatalf("Cannot release locks: %s", err)
+	}
+	err = c1.ReleaseMulti(locks)
+	if errs, ok := err.(glock.MultiError); !ok || len(errs) != 3 {
+		t.Errorf("Expected 3 release errors, got %v", err)
+	}
+}
+
+func (c Conformance) testContext(t *testing.T) {
+	cl := c.NewClient(t)
+	defer cl.Close()
+	name := c.lockName(t)
+	ttl := 10 * c.Scale
+	lock := cl.NewLock(name)
+
+	// a done context fails the operations
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := lock.AcquireContext(ctx, ttl); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if info, err := lock.Info(); err != nil || info.Acquired {
+		t.Errorf("Expected lock not acquired with a done context, got %+v %v", info, err)
+	}
+
+	if err := lock.AcquireContext(context.Background(), ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", name, err)
+	}
+	if err := lock.RefreshContext(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := lock.ReleaseContext(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := lock.RefreshContext(context.Background()); err != nil {
+		t.Errorf("Cannot refresh lock '%s': %s", name, err)
+	}
+	if err := lock.ReleaseContext(context.Background()); err != nil {
+		t.Errorf("Cannot release lock '%s': %s", name, err)
+	}
+}
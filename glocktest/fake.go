@@ -0,0 +1,411 @@
+// Package glocktest provides a fake glock.Client to test the code using locks
+// deterministically, and a conformance suite for the glock.Client
+// implementations.
+package glocktest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+// OpInfo identifies Info and RemainingTTL in Store.FailNext, the other
+// operations being identified by glock.EventAcquire, glock.EventRelease and
+// glock.EventRefresh
+const OpInfo = "info"
+
+// Store holds the fake locks shared by its clients. Its clock only moves with
+// Advance, so that the locks expire exactly when the tests decide.
+type Store struct {
+	mtx     sync.Mutex
+	now     time.Time
+	locks   map[string]*entry
+	fail    map[string][]error
+	changed chan struct{}
+}
+
+// entry is a lock held in the Store
+type entry struct {
+	owner  string
+	data   string
+	expire time.Time
+}
+
+// NewStore returns an empty Store, its clock set to now
+func NewStore() *Store {
+	return &Store{
+		now:     time.Now(),
+		locks:   make(map[string]*entry),
+		fail:    make(map[string][]error),
+		changed: make(chan struct{}),
+	}
+}
+
+// Now returns the time of the store clock
+func (s *Store) Now() time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.now
+}
+
+// Advance moves the store clock forward by d, expiring the locks whose TTL
+// elapsed
+func (s *Store) Advance(d time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.now = s.now.Add(d)
+	s.notify()
+}
+
+// FailNext makes the next operation op (one of glock.EventAcquire,
+// glock.EventRelease, glock.EventRefresh and OpInfo) fail with err, i.e. to
+// simulate connection errors. Failures are queued: each is returned once,
+// in order, without running the operation.
+func (s *Store) FailNext(op string, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.fail[op] = append(s.fail[op], err)
+}
+
+// Hold makes owner hold the lock name for ttl (forever if ttl is
+// glock.NoExpiry), regardless of its current owner, i.e. to simulate a
+// contention with another process
+func (s *Store) Hold(name, owner string, ttl time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.locks[name] = &entry{owner: owner, expire: s.expiry(ttl)}
+	s.notify()
+}
+
+// Drop removes the lock name, as if it expired
+func (s *Store) Drop(name string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.locks, name)
+	s.notify()
+}
+
+// Owner returns the owner of the lock name, empty if not held
+func (s *Store) Owner(name string) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if e, ok := s.get(name); ok {
+		return e.owner
+	}
+	return ""
+}
+
+// NewClient returns a client of the store with the given ID
+func (s *Store) NewClient(id string) *Client {
+	return &Client{id: id, store: s}
+}
+
+func (s *Store) expiry(ttl time.Duration) time.Time {
+	if ttl == glock.NoExpiry {
+		return time.Time{}
+	}
+	return s.now.Add(ttl)
+}
+
+// get returns the entry of a lock, if held. mtx must be held
+func (s *Store) get(name string) (*entry, bool) {
+	e, ok := s.locks[name]
+	if !ok {
+		return nil, false
+	}
+	if !e.expire.IsZero() && !s.now.Before(e.expire) {
+		delete(s.locks, name)
+		return nil, false
+	}
+	return e, true
+}
+
+// ttl returns the remaining TTL of an entry. mtx must be held
+func (s *Store) ttl(e *entry) time.Duration {
+	if e.expire.IsZero() {
+		return glock.NoExpiry
+	}
+	return e.expire.Sub(s.now)
+}
+
+// failure returns the next failure queued for op, if any. mtx must be held
+func (s *Store) failure(op string) error {
+	errs := s.fail[op]
+	if len(errs) == 0 {
+		return nil
+	}
+	s.fail[op] = errs[1:]
+	return errs[0]
+}
+
+// notify wakes up the WaitAcquire calls. mtx must be held
+func (s *Store) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Client is a glock.Client of a Store
+type Client struct {
+	mtx   sync.Mutex
+	id    string
+	store *Store
+}
+
+// ID implements glock.Client
+func (c *Client) ID() string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.id
+}
+
+// SetID implements glock.Client
+func (c *Client) SetID(id string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.id = id
+}
+
+// Reconnect implements glock.Client
+func (c *Client) Reconnect() error {
+	return nil
+}
+
+// Close implements glock.Client
+func (c *Client) Close() {
+}
+
+// Clone implements glock.Client
+func (c *Client) Clone() glock.Client {
+	return c.store.NewClient(c.ID())
+}
+
+// NewLock implements glock.Client
+func (c *Client) NewLock(name string) glock.Lock {
+	return &Lock{name: name, client: c}
+}
+
+// AcquireMulti implements glock.Client
+func (c *Client) AcquireMulti(names []string, ttl time.Duration) ([]glock.Lock, error) {
+	return acquireMulti(c, names, ttl)
+}
+
+// ReleaseMulti implements glock.Client
+func (c *Client) ReleaseMulti(locks []glock.Lock) error {
+	return releaseMulti(locks)
+}
+
+// Lock is a glock.Lock of a Store
+type Lock struct {
+	mtx    sync.Mutex
+	name   string
+	ttl    time.Duration
+	data   string
+	client *Client
+}
+
+// Acquire implements glock.Lock
+func (l *Lock) Acquire(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return glock.ErrInvalidTTL
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	s := l.client.store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.failure(glock.EventAcquire); err != nil {
+		return err
+	}
+	if _, ok := s.get(l.name); ok {
+		return glock.ErrLockHeldByOtherClient
+	}
+	l.ttl = ttl
+	s.locks[l.name] = &entry{owner: l.client.ID(), data: l.data, expire: s.expiry(ttl)}
+	s.notify()
+	return nil
+}
+
+// AcquireContext implements glock.Lock. Fake operations don't block, so ctx
+// is only checked before acquiring the lock
+func (l *Lock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.Acquire(ttl)
+}
+
+// WaitAcquire implements glock.Lock, trying again as soon as a lock of the
+// store changes, or the clock advances
+func (l *Lock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s := l.client.store
+		s.mtx.Lock()
+		changed := s.changed
+		s.mtx.Unlock()
+		err := l.Acquire(ttl)
+		if err != glock.ErrLockHeldByOtherClient {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Refresh implements glock.Lock
+func (l *Lock) Refresh() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.refresh()
+}
+
+func (l *Lock) refresh() error {
+	if l.ttl == 0 {
+		return glock.ErrLockNotHeld
+	}
+	s := l.client.store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.failure(glock.EventRefresh); err != nil {
+		return err
+	}
+	e, ok := s.get(l.name)
+	if !ok || e.owner != l.client.ID() {
+		return glock.ErrLockNotOwned
+	}
+	e.expire = s.expiry(l.ttl)
+	e.data = l.data
+	return nil
+}
+
+// RefreshContext implements glock.Lock, see AcquireContext
+func (l *Lock) RefreshContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.Refresh()
+}
+
+// RefreshTTL implements glock.Lock
+func (l *Lock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return glock.ErrInvalidTTL
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.ttl = ttl
+	return l.refresh()
+}
+
+// Release implements glock.Lock
+func (l *Lock) Release() error {
+	s := l.client.store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.failure(glock.EventRelease); err != nil {
+		return err
+	}
+	e, ok := s.get(l.name)
+	if !ok {
+		return glock.ErrLockExpired
+	}
+	if e.owner != l.client.ID() {
+		return glock.ErrLockNotOwned
+	}
+	delete(s.locks, l.name)
+	s.notify()
+	return nil
+}
+
+// ReleaseContext implements glock.Lock, see AcquireContext
+func (l *Lock) ReleaseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.Release()
+}
+
+// Info implements glock.Lock
+func (l *Lock) Info() (*glock.LockInfo, error) {
+	s := l.client.store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.failure(OpInfo); err != nil {
+		return nil, err
+	}
+	e, ok := s.get(l.name)
+	if !ok {
+		return &glock.LockInfo{Name: l.name}, nil
+	}
+	return &glock.LockInfo{
+		Name:     l.name,
+		Acquired: true,
+		Owner:    e.owner,
+		TTL:      s.ttl(e),
+		Data:     e.data,
+	}, nil
+}
+
+// RemainingTTL implements glock.Lock
+func (l *Lock) RemainingTTL() (time.Duration, error) {
+	s := l.client.store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.failure(OpInfo); err != nil {
+		return 0, err
+	}
+	e, ok := s.get(l.name)
+	if !ok {
+		return 0, nil
+	}
+	return s.ttl(e), nil
+}
+
+// SetData implements glock.Lock. The data is stored when the lock is
+// acquired or refreshed
+func (l *Lock) SetData(data string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.data = data
+}
+
+// acquireMulti acquires the locks like glock.RedisClient.AcquireMulti
+func acquireMulti(c glock.Client, names []string, ttl time.Duration) ([]glock.Lock, error) {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	locks := make([]glock.Lock, 0, len(sorted))
+	for i, name := range sorted {
+		if i > 0 && name == sorted[i-1] {
+			continue
+		}
+		lock := c.NewLock(name)
+		if err := lock.Acquire(ttl); err != nil {
+			releaseMulti(locks)
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+// releaseMulti releases the locks in reverse order
+func releaseMulti(locks []glock.Lock) error {
+	var errs glock.MultiError
+	for i := len(locks) - 1; i >= 0; i-- {
+		if err := locks[i].Release(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package glocktest
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/gbagnoli/glock.v1"
+)
+
+var clientSeq int64
+
+func clientID() string {
+	return "client-" + strconv.FormatInt(atomic.AddInt64(&clientSeq, 1), 10)
+}
+
+func TestFakeConformance(t *testing.T) {
+	s := NewStore()
+	Conformance{
+		NewClient: func(t *testing.T) glock.Client { return s.NewClient(clientID()) },
+		Sleep:     s.Advance,
+	}.Run(t)
+}
+
+func TestMemoryConformance(t *testing.T) {
+	Conformance{
+		NewClient: func(t *testing.T) glock.Client { return glock.NewMemoryClient(clientID()) },
+		Scale:     20 * time.Millisecond,
+	}.Run(t)
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+	c := s.NewClient("client")
+	lock := c.NewLock("lock")
+
+	// contention with another process
+	s.Hold("lock", "other", time.Minute)
+	if err := lock.Acquire(time.Minute); err != glock.ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+	s.Advance(time.Minute)
+	if owner := s.Owner("lock"); owner != "" {
+		t.Errorf("Expected the lock to expire, held by %q", owner)
+	}
+
+	// scripted failures
+	failure := errors.New("connection reset")
+	s.FailNext(glock.EventAcquire, failure)
+	if err := lock.Acquire(time.Minute); err != failure {
+		t.Errorf("Expected the scripted failure, got %v", err)
+	}
+	if err := lock.Acquire(time.Minute); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	s.FailNext(OpInfo, failure)
+	if _, err := lock.RemainingTTL(); err != failure {
+		t.Errorf("Expected the scripted failure, got %v", err)
+	}
+	s.Advance(20 * time.Second)
+	if ttl, err := lock.RemainingTTL(); err != nil || ttl != 40*time.Second {
+		t.Errorf("Expected 40s TTL, got %v %v", ttl, err)
+	}
+
+	// the lock is lost
+	s.Drop("lock")
+	if err := lock.Refresh(); err != glock.ErrLockNotOwned {
+		t.Errorf("Expected ErrLockNotOwned, got %v", err)
+	}
+	s.Hold("lock", "other", glock.NoExpiry)
+	if info, err := lock.Info(); err != nil || info.Owner != "other" || info.TTL != glock.NoExpiry {
+		t.Errorf("Expected lock held by other with no expiry, got %+v %v", info, err)
+	}
+}
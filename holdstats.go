@@ -0,0 +1,83 @@
+package glock
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// HoldStats are statistics about how long a lock was held, over the
+// HoldStatsRetention period
+type HoldStats struct {
+	Count int
+	Min   time.Duration
+	Max   time.Duration
+	Avg   time.Duration
+}
+
+// holdsKey returns the key of the sorted set of hold durations for the lock,
+// scored by release time in milliseconds
+func (l *RedisLock) holdsKey() string {
+	return l.companionKey("holds")
+}
+
+// recordHold appends the hold duration of the lock to its history, trimming
+// the entries older than the retention
+func (c *RedisClient) recordHold(l *RedisLock, held time.Duration) {
+	now := time.Now()
+	key := l.holdsKey()
+	retention := c.opts.HoldStatsRetention
+	// the release time makes members unique
+	member := strconv.FormatInt(now.UnixNano(), 10) + ":" + strconv.FormatInt(int64(held), 10)
+	conn := c.get()
+	defer c.put(conn)
+	conn.Send("MULTI")
+	conn.Send("ZADD", key, msec(now), member)
+	conn.Send("ZREMRANGEBYSCORE", key, "-inf", "("+strconv.FormatInt(msec(now.Add(-retention)), 10))
+	conn.Send("PEXPIRE", key, int64(retention/time.Millisecond))
+	conn.Do("EXEC")
+}
+
+// HoldStats returns statistics about the hold durations of the lock with the
+// given name, created with NewLock, over the HoldStatsRetention period.
+// Locks released by another client are counted only if that client has
+// HoldStatsRetention set.
+func (c *RedisClient) HoldStats(name string) (HoldStats, error) {
+	var stats HoldStats
+	l := c.newLock(name, "")
+	if l.err != nil {
+		return stats, l.err
+	}
+	min := msec(time.Now().Add(-c.opts.HoldStatsRetention))
+	members, err := redis.Strings(c.read("ZRANGEBYSCORE", l.holdsKey(), min, "+inf"))
+	if err != nil {
+		return stats, err
+	}
+	var total time.Duration
+	for _, member := range members {
+		i := strings.IndexByte(member, ':')
+		ns, err := strconv.ParseInt(member[i+1:], 10, 64)
+		if err != nil {
+			return stats, err
+		}
+		held := time.Duration(ns)
+		if stats.Count == 0 || held < stats.Min {
+			stats.Min = held
+		}
+		if held > stats.Max {
+			stats.Max = held
+		}
+		total += held
+		stats.Count++
+	}
+	if stats.Count > 0 {
+		stats.Avg = total / time.Duration(stats.Count)
+	}
+	return stats, nil
+}
+
+func msec(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
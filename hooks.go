@@ -0,0 +1,63 @@
+package glock
+
+import "time"
+
+// HookFunc is called after a lock operation with the lock name, whether the
+// operation succeeded, how long it took and the error it returned, if any
+type HookFunc func(name string, ok bool, dur time.Duration, err error)
+
+// Hooks are callbacks invoked after the lock operations of a RedisClient,
+// i.e. to record metrics or traces. Any of them can be nil.
+// Hooks are called synchronously by the operation: a panicking hook is
+// recovered, and never changes the result of the operation.
+type Hooks struct {
+	// OnAcquire is called after each acquisition attempt: ok is false if the
+	// lock was not acquired, err is ErrLockHeldByOtherClient if it was held
+	OnAcquire HookFunc
+	// OnRelease is called after each release
+	OnRelease HookFunc
+	// OnRefresh is called after each refresh, err being ErrLockNotOwned if
+	// the lock was lost
+	OnRefresh HookFunc
+	// OnAcquireFailed is called after each acquisition attempt that didn't
+	// acquire the lock, after OnAcquire
+	OnAcquireFailed HookFunc
+	// OnExpired is called when this client finds that a lock it held expired
+	// or was taken by another client: when a refresh fails with
+	// ErrLockNotOwned or a release with ErrLockExpired, after OnRefresh or
+	// OnRelease
+	OnExpired HookFunc
+}
+
+// call calls the hooks for op, if any
+func (h Hooks) call(op, name string, start time.Time, err error) {
+	dur := time.Since(start)
+	switch op {
+	case EventAcquire:
+		h.run(h.OnAcquire, name, dur, err)
+		if err != nil {
+			h.run(h.OnAcquireFailed, name, dur, err)
+		}
+	case EventRelease:
+		h.run(h.OnRelease, name, dur, err)
+		if err == ErrLockExpired {
+			h.run(h.OnExpired, name, dur, err)
+		}
+	case EventRefresh:
+		h.run(h.OnRefresh, name, dur, err)
+	case EventLost:
+		h.run(h.OnRefresh, name, dur, err)
+		h.run(h.OnExpired, name, dur, err)
+	}
+}
+
+// run calls hook, if not nil, recovering its panics
+func (h Hooks) run(hook HookFunc, name string, dur time.Duration, err error) {
+	if hook == nil {
+		return
+	}
+	defer func() {
+		recover()
+	}()
+	hook(name, err == nil, dur, err)
+}
@@ -3,13 +3,21 @@
 set -eu
 
 if [ $# -ne 1 ]; then
-  echo >&2 "Usage: $0 <memory|redis|cassandra:x.y.z>"
+  echo >&2 "Usage: $0 <memory|redis|etcd|consul|zookeeper|postgres|dynamodb|cassandra:x.y.z>"
   exit 1
 fi
 
 DB="$1"
 VERSION=""
 CASSANDRA_WAIT_TIME="${CASSANDRA_WAIT_TIME:-10}"
+# the servers of the other backends run in docker: IMAGE is started with
+# the docker run OPTS and ARGS, publishing PORT, until READY succeeds
+IMAGE=""
+OPTS=""
+ARGS=""
+PORT=""
+READY=""
+DOCKER_WAIT_TIME="${DOCKER_WAIT_TIME:-30}"
 
 case $DB in
   "memory")
@@ -20,6 +28,44 @@ case $DB in
     TAGS='redis'
     ;;
 
+  "etcd")
+    TAGS='etcd'
+    IMAGE='quay.io/coreos/etcd:v3.4.3'
+    ARGS='etcd --listen-client-urls http://0.0.0.0:2379 --advertise-client-urls http://127.0.0.1:2379'
+    PORT=2379
+    READY='docker exec -e ETCDCTL_API=3 glock-test etcdctl endpoint health'
+    ;;
+
+  "consul")
+    TAGS='consul'
+    IMAGE='consul:1.6.2'
+    ARGS='agent -dev -client 0.0.0.0'
+    PORT=8500
+    READY='curl -sf http://127.0.0.1:8500/v1/status/leader | grep -q :'
+    ;;
+
+  "zookeeper")
+    TAGS='zookeeper'
+    IMAGE='zookeeper:3.5'
+    PORT=2181
+    READY='docker exec glock-test zkServer.sh status'
+    ;;
+
+  "postgres")
+    TAGS='postgres'
+    IMAGE='postgres:12'
+    OPTS='-e POSTGRES_DB=glock_test -e POSTGRES_HOST_AUTH_METHOD=trust'
+    PORT=5432
+    READY='docker exec glock-test pg_isready -h 127.0.0.1 -U postgres'
+    ;;
+
+  "dynamodb")
+    TAGS='dynamodb'
+    IMAGE='amazon/dynamodb-local:1.12.0'
+    PORT=8000
+    READY='curl -s -o /dev/null http://127.0.0.1:8000'
+    ;;
+
   "cassandra")
     echo >&2 "Missing cassandra version. (cassandra:x.y.z)"
     exit 1
@@ -78,5 +124,28 @@ if [ ! -z "$VERSION" ]; then
   echo " ok"
 fi
 
+if [ ! -z "$IMAGE" ]; then
+  function cleanup {
+    docker rm -f glock-test &>/dev/null || true
+  }
+
+  cleanup
+  echo "Starting $DB with $IMAGE"
+  docker run -d --name glock-test -p "$PORT:$PORT" $OPTS "$IMAGE" $ARGS >/dev/null
+  trap cleanup EXIT
+
+  echo -n "Waiting for $DB to settle "
+  for (( i=0; i < DOCKER_WAIT_TIME; i++ )); do
+    echo -n "."
+    eval "$READY" &>/dev/null && break
+    sleep 1
+  done
+  if [ "$i" -eq "$DOCKER_WAIT_TIME" ]; then
+    echo >&2 "$DB failed to start in $DOCKER_WAIT_TIME seconds"
+    exit 1
+  fi
+  echo " ok"
+fi
+
 go test -v --tags="$TAGS"
 exit $?
@@ -0,0 +1,162 @@
+package glock
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LeaderElectorOptions configures a LeaderElector
+type LeaderElectorOptions struct {
+	// TTL is the TTL of the leadership lock: a leader that stops refreshing
+	// it, i.e. because it crashed, is replaced after TTL. Defaults to 10s
+	TTL time.Duration
+	// RefreshInterval is how often the leader refreshes the lock. Defaults
+	// to TTL/3
+	RefreshInterval time.Duration
+	// RetryInterval is how often the followers try to acquire the lock.
+	// Defaults to RefreshInterval
+	RetryInterval time.Duration
+	// Data is set as the data of the lock while this client leads
+	Data string
+	// OnElected, if set, is called when this client becomes the leader
+	OnElected func()
+	// OnResigned, if set, is called when this client stops being the
+	// leader, with the error that made it lose the lock, or nil if the
+	// elector was stopped
+	OnResigned func(err error)
+	// OnError, if set, is called when an attempt to acquire or refresh the
+	// lock fails with an error, as opposed to the lock being held by another
+	// client. Optional
+	OnError func(err error)
+}
+
+// LeaderElector elects a leader among the clients campaigning for the same
+// lock: the client holding the lock is the leader, and refreshes it until it
+// is stopped, while the others retry to acquire it. A leader that can't
+// refresh the lock (i.e. because redis is unreachable) resigns before the
+// lock expires, so that two clients never consider themselves the leader at
+// the same time, within the clock drift of the backend.
+// The callbacks are called from the goroutine of the elector, one at a time.
+type LeaderElector struct {
+	client Client
+	name   string
+	opts   LeaderElectorOptions
+	leader int32
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewLeaderElector returns a LeaderElector campaigning for the lock with the
+// given name. The elector uses its own connection, cloned from the client.
+func NewLeaderElector(client Client, name string, opts LeaderElectorOptions) *LeaderElector {
+	if opts.TTL <= 0 {
+		opts.TTL = 10 * time.Second
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = opts.TTL / 3
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = opts.RefreshInterval
+	}
+	return &LeaderElector{client: client, name: name, opts: opts}
+}
+
+// Start starts campaigning in a background goroutine, until Stop is called
+func (e *LeaderElector) Start() {
+	e.stop = make(chan struct{})
+	e.wg.Add(1)
+	go e.run(e.client.Clone(), e.stop)
+}
+
+// Stop stops campaigning and waits for the background goroutine to exit. If
+// this client is the leader, it resigns, releasing the lock.
+func (e *LeaderElector) Stop() {
+	if e.stop != nil {
+		close(e.stop)
+		e.wg.Wait()
+		e.stop = nil
+	}
+}
+
+// IsLeader tells if this client is currently the leader
+func (e *LeaderElector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// Leader returns the ID of the current leader, or an empty string if there
+// is none
+func (e *LeaderElector) Leader() (string, error) {
+	info, err := e.client.NewLock(e.name).Info()
+	if err != nil {
+		return "", err
+	}
+	return info.Owner, nil
+}
+
+func (e *LeaderElector) run(client Client, stop <-chan struct{}) {
+	defer e.wg.Done()
+	client.Reconnect()
+	defer client.Close()
+	lock := client.NewLock(e.name)
+	lock.SetData(e.opts.Data)
+	var refreshed time.Time
+	for {
+		var wait time.Duration
+		if e.IsLeader() {
+			wait = e.opts.RefreshInterval
+			err := lock.Refresh()
+			switch {
+			case err == nil:
+				refreshed = time.Now()
+			case err == ErrLockNotOwned || err == ErrLockExpired ||
+				time.Since(refreshed)+e.opts.RefreshInterval >= e.opts.TTL:
+				// the lock is lost, or may expire before the next attempt
+				e.resign(err)
+				wait = e.opts.RetryInterval
+			default:
+				e.failed(err)
+			}
+		} else {
+			wait = e.opts.RetryInterval
+			start := time.Now()
+			err := lock.Acquire(e.opts.TTL)
+			switch err {
+			case nil:
+				refreshed = start
+				wait = e.opts.RefreshInterval
+				atomic.StoreInt32(&e.leader, 1)
+				if e.opts.OnElected != nil {
+					e.opts.OnElected()
+				}
+			case ErrLockHeldByOtherClient:
+			default:
+				e.failed(err)
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			if e.IsLeader() {
+				lock.Release()
+				e.resign(nil)
+			}
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (e *LeaderElector) resign(err error) {
+	atomic.StoreInt32(&e.leader, 0)
+	if e.opts.OnResigned != nil {
+		e.opts.OnResigned(err)
+	}
+}
+
+func (e *LeaderElector) failed(err error) {
+	if e.opts.OnError != nil {
+		e.opts.OnError(err)
+	}
+}
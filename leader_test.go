@@ -0,0 +1,75 @@
+package glock
+
+import (
+	"testing"
+	"time"
+)
+
+// waitFor polls cond every scale until it's true or timeout elapses
+func waitFor(cond func() bool, scale, timeout time.Duration) bool {
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(scale) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func testLeaderElector(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1, c2 := cfun(t), cfun(t)
+	defer c1.Close()
+	defer c2.Close()
+	elected := make(chan string, 2)
+	resigned := make(chan error, 2)
+	opts := LeaderElectorOptions{
+		TTL:        300 * scale,
+		OnElected:  func() { elected <- "elected" },
+		OnResigned: func(err error) { resigned <- err },
+		OnError:    func(err error) { t.Errorf("Unexpected error: %s", err) },
+	}
+	e1 := NewLeaderElector(c1, lockName, opts)
+	e1.Start()
+	defer e1.Stop()
+	if !waitFor(e1.IsLeader, scale, time.Second) {
+		t.Fatal("First elector not elected")
+	}
+	<-elected
+
+	opts.OnElected = nil
+	opts.OnResigned = nil
+	e2 := NewLeaderElector(c2, lockName, opts)
+	e2.Start()
+	defer e2.Stop()
+	// the leader keeps the lock beyond its TTL
+	time.Sleep(opts.TTL * 2)
+	if !e1.IsLeader() || e2.IsLeader() {
+		t.Errorf("Expected the first elector to lead, got %v %v", e1.IsLeader(), e2.IsLeader())
+	}
+	if leader, err := e2.Leader(); err != nil || leader != c1.ID() {
+		t.Errorf("Expected leader %s, got %q %v", c1.ID(), leader, err)
+	}
+
+	// the leader resigns when stopped, and the follower takes over
+	e1.Stop()
+	if e1.IsLeader() {
+		t.Error("Stopped elector still leading")
+	}
+	select {
+	case err := <-resigned:
+		if err != nil {
+			t.Errorf("Expected resignation without error, got %s", err)
+		}
+	default:
+		t.Error("OnResigned not called")
+	}
+	if !waitFor(e2.IsLeader, scale, time.Second) {
+		t.Fatal("Second elector not elected")
+	}
+	if leader, err := e1.Leader(); err != nil || leader != c2.ID() {
+		t.Errorf("Expected leader %s, got %q %v", c2.ID(), leader, err)
+	}
+	e2.Stop()
+	if leader, err := e1.Leader(); err != nil || leader != "" {
+		t.Errorf("Expected no leader, got %q %v", leader, err)
+	}
+}
@@ -1,7 +1,11 @@
 package glock
 
 import (
+	"context"
 	"errors"
+	"math/rand"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -25,6 +29,16 @@ type Client interface {
 
 	// Clone returns a disconnected copy of the client
 	Clone() Client
+
+	// AcquireMulti acquires the locks with the given names, in sorted order
+	// so that concurrent callers cannot deadlock. Either all the locks are
+	// acquired, or none: if an acquisition fails, the locks already acquired
+	// are released and its error is returned.
+	AcquireMulti(names []string, ttl time.Duration) ([]Lock, error)
+
+	// ReleaseMulti releases all the given locks, returning a MultiError with
+	// the errors of the releases that failed, if any
+	ReleaseMulti(locks []Lock) error
 }
 
 // Lock represent a lock in the store
@@ -33,22 +47,43 @@ type Lock interface {
 	// Info returns a LockInfo struct with information about this lock
 	Info() (*LockInfo, error)
 
+	// RemainingTTL returns the remaining TTL of the lock, which is cheaper
+	// than Info. It returns 0 if the lock is not held, NoExpiry if the lock
+	// has no expiry.
+	RemainingTTL() (time.Duration, error)
+
 	// Acquire tries to acquire the lock for a specified duration
 	// The lock must not be locked.
 	Acquire(ttl time.Duration) error
 
+	// AcquireContext is like Acquire, with the operation bounded by ctx: it
+	// returns ctx.Err() if ctx is done before the store replies
+	AcquireContext(ctx context.Context, ttl time.Duration) error
+
+	// WaitAcquire acquires the lock like Acquire, retrying while it is held
+	// by another client until ctx is done, in which case it returns ctx.Err()
+	WaitAcquire(ctx context.Context, ttl time.Duration) error
+
 	// Refresh extends the validity of the lock by its ttl
 	// The lock must be acquired by the current client.
 	Refresh() error
 
+	// RefreshContext is like Refresh, with the operation bounded by ctx
+	RefreshContext(ctx context.Context) error
+
 	// RefreshTTL extends the validity of the lock by the given ttl.
 	// The lock must be acquired by the current client
 	RefreshTTL(ttl time.Duration) error
 
 	// Release removed the lock from the store.
-	// The lock must be owned by the current client
+	// The lock must be owned by the current client: it returns
+	// ErrLockExpired if the lock doesn't exist, ErrLockNotOwned if it's held
+	// by another client
 	Release() error
 
+	// ReleaseContext is like Release, with the operation bounded by ctx
+	ReleaseContext(ctx context.Context) error
+
 	// SetData sets the data payload for the lock.
 	// The data is set into the backend only when the lock is acquired,
 	// so any call to this method after acquisition won't update the value.
@@ -63,20 +98,285 @@ type LockInfo struct {
 	Acquired bool
 	// Owner if the ClientID of the client owning the lock, if any
 	Owner string
-	// The remaining TTL until the lock is automatically expired
+	// The remaining TTL until the lock is automatically expired.
+	// It is NoExpiry if the lock has been acquired without a TTL
 	TTL time.Duration
 	// Data associated with the lock, if any
 	Data string
+	// Clock is the logical timestamp of the acquisition, if recorded by the
+	// driver (0 otherwise). Timestamps are strictly increasing across all the
+	// acquisitions in a namespace: of two acquisitions, the one with the
+	// greater Clock happened after the other, regardless of wall clocks.
+	Clock uint64
+	// Token is the fencing token of the acquisition, if issued by the driver
+	// (0 otherwise), see RedisLock.AcquireWithToken
+	Token uint64
+	// OwnerMetadata describes the process holding the lock, if recorded by
+	// the driver (nil otherwise), see RedisOptions.RecordOwner
+	OwnerMetadata *OwnerMetadata
+
+	codec Codec
+}
+
+// OwnerMetadata describes the process that acquired a lock, to find the
+// holder of a stuck lock
+type OwnerMetadata struct {
+	// Hostname is the name of the host of the process
+	Hostname string `json:"hostname,omitempty"`
+	// PID is the process ID
+	PID int `json:"pid,omitempty"`
+	// AcquiredAt is when the lock was acquired, on the clock of the host
+	AcquiredAt time.Time `json:"acquired_at"`
+	// Labels are custom labels set by the application, i.e. the job name
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
+// NoExpiry is the TTL reported for locks that never expire
+const NoExpiry = time.Duration(-1)
+
 var (
 	// ErrInvalidTTL is returnend when the TTL specified is not a valid TTL
 	ErrInvalidTTL = errors.New("Invalid ttl value")
 	// ErrLockHeldByOtherClient is returned when the operation cannot be performed as the lock is
 	// not held by the current client
-	ErrLockHeldByOtherClient = errors.New("Lock held by other client")
+	ErrLockHeldByOtherClient = newClassError("Lock held by other client", ErrNotAcquired)
 	// ErrInvalidLock is returned when the lock name is invalid
 	ErrInvalidLock = errors.New("Invalid lock name")
+	// ErrNoQuorum is returned by RedlockClient and RedlockLock when an
+	// operation doesn't succeed on a quorum of nodes
+	ErrNoQuorum = errors.New("Operation failed on a quorum of nodes")
+	// ErrQuorumLost is returned by RedlockLock.Refresh when the lock cannot
+	// be refreshed on a quorum of nodes anymore, i.e. because some of them
+	// went down while it was held: the lock must be considered lost
+	ErrQuorumLost = errors.New("Lock quorum lost while held")
+	// ErrAutoRefreshRunning is returned when starting the auto refresh of a
+	// lock that is already being refreshed
+	ErrAutoRefreshRunning = errors.New("Lock auto refresh already running")
 	// ErrLockNotOwned is returned when either the lock is not existing or held by another client
 	ErrLockNotOwned = errors.New("Lock is not held by current client")
+	// ErrLockExpired is returned by Release when the lock doesn't exist
+	// anymore, i.e. because its TTL expired before the release, while
+	// ErrLockNotOwned means that it's held by another client
+	ErrLockExpired = errors.New("Lock expired before being released")
+	// ErrValidityElapsed is returned when the TTL of a lock elapsed, net of
+	// the clock drift allowance, before its acquisition completed. The lock
+	// is released
+	ErrValidityElapsed = errors.New("Lock validity elapsed during acquisition")
+	// ErrReentrantDenied is returned when a client tries to acquire a lock it already holds
+	// and reentrancy has been explicitly denied
+	ErrReentrantDenied = newClassError("Lock already held by current client", ErrNotAcquired)
+	// ErrLockPersistent is returned when trying to refresh a lock that has no expiry
+	ErrLockPersistent = errors.New("Lock has no expiry and cannot be refreshed")
+	// ErrRateLimited is returned when a lock has been acquired too many times in
+	// the configured window
+	ErrRateLimited = newClassError("Lock acquisition rate limit exceeded", ErrNotAcquired)
+	// ErrInvalidDataValue is returned when decoding lock data into something
+	// which is not a non-nil pointer
+	ErrInvalidDataValue = errors.New("Lock data can only be decoded into a non-nil pointer")
+	// ErrReadOnlyReplica is returned when a write operation reached a read-only
+	// replica, i.e. after a failover. The client must reconnect to the current primary
+	ErrReadOnlyReplica = errors.New("Write sent to a read-only replica, reconnect to the primary")
+	// ErrLockOrphaned is returned when the client ID changed after the lock
+	// was acquired, so that the lock cannot be refreshed or released anymore
+	ErrLockOrphaned = errors.New("Client ID changed since the lock was acquired")
+	// ErrDataTooLarge is returned when the lock data exceeds the configured
+	// maximum size
+	ErrDataTooLarge = errors.New("Lock data exceeds the maximum size")
+	// ErrDataKeyDisabled is returned when setting the data of a lock of a
+	// client that doesn't store it, see RedisOptions.DisableDataKey
+	ErrDataKeyDisabled = errors.New("Lock data is disabled")
+	// ErrUnknownLockClass is returned when using a lock class that was not
+	// defined
+	ErrUnknownLockClass = errors.New("Lock class not defined")
+	// ErrLockUnhealthy is returned by a heartbeat stopped because the Healthy
+	// callback of the lock returned false
+	ErrLockUnhealthy = errors.New("Lock holder reported as unhealthy")
+	// ErrStaleFence is returned when acquiring a lock with a fencing token not
+	// greater than the last one stored for the lock
+	ErrStaleFence = errors.New("Fencing token not greater than the last one")
+	// ErrLockNotHeld is returned when refreshing a lock that was never
+	// acquired, and has no TTL to refresh it with
+	ErrLockNotHeld = errors.New("Lock was never acquired")
+	// ErrInvalidURL is returned for a malformed RedisOptions.URL
+	ErrInvalidURL = errors.New("Invalid redis URL, expected redis[s]://[[user]:password@]host[:port][/db]")
+	// ErrHeartbeatMissed is returned by a heartbeat stopped because the
+	// application did not call LockManager.Heartbeat in time
+	ErrHeartbeatMissed = errors.New("Application heartbeat missed, lock released")
+	// ErrAcquireNotVerified is returned when an acquired lock can't be read
+	// back, see RedisOptions.VerifyAcquire. The lock is released.
+	ErrAcquireNotVerified = errors.New("Acquired lock not visible on read back")
+	// ErrUnknownEventVersion is returned when decoding an event encoded with
+	// an unsupported schema version
+	ErrUnknownEventVersion = errors.New("Unknown event schema version")
+	// ErrMasterNotFound is returned when connecting if none of the sentinels
+	// knows the master, or the master they report is not a master (i.e. during
+	// a failover), see RedisOptions.SentinelAddresses
+	ErrMasterNotFound = errors.New("Redis master not found by the sentinels")
+	// ErrClusterUnsupported is returned when creating a redis client for a
+	// cluster with options relying on a single node, see
+	// RedisOptions.ClusterAddresses
+	ErrClusterUnsupported = errors.New("Option not supported on redis cluster")
+	// ErrInvalidLockName is returned by the operations on a lock whose name
+	// contains control characters or would make its key overlap with the
+	// other keys of the namespace, i.e. ending with the data key suffix
+	ErrInvalidLockName = errors.New("Lock name contains control characters or overlaps other keys")
+	// ErrSemaphoreFull is returned when acquiring a semaphore whose slots are
+	// all taken by other clients
+	ErrSemaphoreFull = newClassError("No free slot in the semaphore", ErrNotAcquired)
+	// ErrInvalidCapacity is returned by the operations on a semaphore created
+	// with a capacity lower than 1
+	ErrInvalidCapacity = errors.New("Invalid semaphore capacity")
+	// ErrDraining is returned when acquiring a slot of a semaphore being
+	// drained, see RedisSemaphore.Drain
+	ErrDraining = errors.New("Semaphore draining, no new slot granted")
+)
+
+// sentinels are the errors restored as such when decoding events and the
+// replies of the lock service. Every error declared above must be listed,
+// see TestSentinels.
+var sentinels = []error{
+	ErrNotAcquired, ErrConnection,
+	ErrInvalidTTL, ErrLockHeldByOtherClient, ErrInvalidLock, ErrNoQuorum, ErrQuorumLost,
+	ErrAutoRefreshRunning, ErrLockNotOwned, ErrLockExpired, ErrValidityElapsed,
+	ErrReentrantDenied, ErrLockPersistent, ErrRateLimited, ErrInvalidDataValue,
+	ErrReadOnlyReplica, ErrLockOrphaned, ErrDataTooLarge, ErrDataKeyDisabled,
+	ErrUnknownLockClass, ErrLockUnhealthy, ErrStaleFence, ErrLockNotHeld,
+	ErrInvalidURL, ErrHeartbeatMissed, ErrAcquireNotVerified,
+	ErrUnknownEventVersion, ErrMasterNotFound, ErrClusterUnsupported,
+	ErrInvalidLockName, ErrSemaphoreFull, ErrInvalidCapacity, ErrDraining,
+}
+
+// MultiError is returned by ReleaseMulti with the errors of the releases
+// that failed, in order
+type MultiError []error
+
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+const (
+	defaultWaitBackoff    = 50 * time.Millisecond
+	defaultWaitMaxBackoff = time.Second
 )
+
+// AcquireWait acquires the lock with WaitAcquire, waiting up to maxWait for
+// the lock to be released. It returns ErrLockHeldByOtherClient if the lock is
+// still held after maxWait. The other errors, i.e. the deadline exceeded by an
+// attempt timing out in the backend, are returned as is.
+func AcquireWait(l Lock, ttl, maxWait time.Duration) error {
+	var last error
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), lastAttemptKey{}, &last), maxWait)
+	defer cancel()
+	err := l.WaitAcquire(ctx, ttl)
+	if err == context.DeadlineExceeded && last == ErrLockHeldByOtherClient {
+		return ErrLockHeldByOtherClient
+	}
+	return err
+}
+
+// lastAttemptKey is the context key of the error of the last attempt of
+// WaitAcquire, recorded for AcquireWait
+type lastAttemptKey struct{}
+
+// recordAttempt records err as the error of the last attempt of WaitAcquire,
+// if requested by ctx
+func recordAttempt(ctx context.Context, err error) {
+	if last, ok := ctx.Value(lastAttemptKey{}).(*error); ok {
+		*last = err
+	}
+}
+
+// waitAcquire calls acquire until it doesn't return ErrLockHeldByOtherClient
+// or ctx is done, sleeping backoff between attempts, doubled at each attempt
+// up to maxBackoff.
+func waitAcquire(ctx context.Context, acquire func() error, backoff, maxBackoff time.Duration) error {
+	return waitAcquireNotified(ctx, SystemClock, acquire, backoffDelay(backoff, maxBackoff, 0), nil)
+}
+
+// backoffDelay returns a function returning the delay before the given
+// attempt: backoff doubled at each attempt up to maxBackoff, reduced by a
+// random fraction up to jitter
+func backoffDelay(backoff, maxBackoff time.Duration, jitter float64) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := backoff << uint(attempt)
+		if d <= 0 || d > maxBackoff {
+			d = maxBackoff
+		}
+		return d - time.Duration(jitter*rand.Float64()*float64(d))
+	}
+}
+
+// waitAcquireNotified is like waitAcquire, sleeping delay(attempt) on clock
+// between attempts and trying again as soon as wake receives, i.e. when the
+// lock is released.
+func waitAcquireNotified(ctx context.Context, clock Clock, acquire func() error,
+	delay func(attempt int) time.Duration, wake <-chan struct{}) error {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := acquire()
+		recordAttempt(ctx, err)
+		if err != ErrLockHeldByOtherClient {
+			return err
+		}
+		timer := clock.NewTimer(delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		case <-wake:
+			timer.Stop()
+		}
+	}
+}
+
+// acquireMulti implements Client.AcquireMulti for c
+func acquireMulti(c Client, names []string, ttl time.Duration) ([]Lock, error) {
+	sorted := sortedNames(names)
+	locks := make([]Lock, 0, len(sorted))
+	for _, name := range sorted {
+		lock := c.NewLock(name)
+		if err := lock.Acquire(ttl); err != nil {
+			releaseMulti(locks)
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+// sortedNames returns a sorted copy of names without duplicates, the order in
+// which multiple locks are acquired to avoid deadlocks
+func sortedNames(names []string) []string {
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+	res := sorted[:0]
+	for i, name := range sorted {
+		if i == 0 || name != sorted[i-1] {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// releaseMulti implements Client.ReleaseMulti, releasing the locks in reverse
+// order
+func releaseMulti(locks []Lock) error {
+	var errs MultiError
+	for i := len(locks) - 1; i >= 0; i-- {
+		if err := locks[i].Release(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package glock
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -128,8 +129,8 @@ func testLock(t *testing.T, cfun newClientFunc, scale time.Duration) {
 
 	// Releasing a lock twice is an error
 	err = lock1.Release()
-	if err != ErrLockNotOwned {
-		t.Fatalf("Releasing a lock twice should return '%s', got: '%s'", ErrLockNotOwned, err)
+	if err != ErrLockExpired {
+		t.Fatalf("Releasing a lock twice should return '%s', got: '%s'", ErrLockExpired, err)
 	}
 
 	// Refreshing a lock not held is an error
@@ -162,7 +163,329 @@ func testLock(t *testing.T, cfun newClientFunc, scale time.Duration) {
 		t.Fatalf("Lock should be expired but refresh was succesful: %s", err)
 	}
 	err = lock1.Release()
-	if err != ErrLockNotOwned {
+	if err != ErrLockExpired {
 		t.Fatalf("Lock should be expired but release was succesful: %s", err)
 	}
 }
+
+func testLockRemainingTTL(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	lock := c1.NewLock(lockName)
+	ttl := time.Duration(ttlLength) * scale
+
+	remaining, err := lock.RemainingTTL()
+	if err != nil {
+		t.Fatalf("Error while getting remaining TTL: '%s'", err)
+	}
+	if remaining != 0 {
+		t.Errorf("Expected no remaining TTL for a lock not held, got %v", remaining)
+	}
+
+	err = lock.Acquire(ttl)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	remaining, err = lock.RemainingTTL()
+	if err != nil {
+		t.Fatalf("Error while getting remaining TTL: '%s'", err)
+	}
+	if remaining <= 0 || remaining > ttl {
+		t.Errorf("Expected remaining TTL in (0, %v], got %v", ttl, remaining)
+	}
+
+	err = lock.Release()
+	if err != nil {
+		t.Fatalf("Error in release: %s", err)
+	}
+	remaining, err = lock.RemainingTTL()
+	if err != nil {
+		t.Fatalf("Error while getting remaining TTL: '%s'", err)
+	}
+	if remaining != 0 {
+		t.Errorf("Expected no remaining TTL for a released lock, got %v", remaining)
+	}
+}
+
+func testLockRefreshNotHeld(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	lock := c1.NewLock(lockName)
+
+	// Refreshing a lock never acquired has no TTL to refresh it with
+	err := lock.Refresh()
+	if err != ErrLockNotHeld {
+		t.Errorf("Refreshing a lock never acquired should return '%s', got: '%s'", ErrLockNotHeld, err)
+	}
+
+	// An invalid TTL is reported as such, regardless of the lock state
+	err = lock.RefreshTTL(scale / 2)
+	if err != ErrInvalidTTL {
+		t.Errorf("Expected error '%s' with TTL %v, got '%s'", ErrInvalidTTL, scale/2, err)
+	}
+
+	err = lock.Acquire(time.Duration(ttlLength) * scale)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	defer lock.Release()
+	err = lock.RefreshTTL(scale / 2)
+	if err != ErrInvalidTTL {
+		t.Errorf("Expected error '%s' with TTL %v, got '%s'", ErrInvalidTTL, scale/2, err)
+	}
+	err = lock.Refresh()
+	if err != nil {
+		t.Errorf("Error while refreshing lock: '%s'", err)
+	}
+}
+
+func testLockWaitAcquire(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	c2 := cfun(t)
+	ttl := time.Duration(ttlLength) * scale
+
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+	lock2.SetData("client2")
+
+	err := lock1.Acquire(ttl)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+	defer cancel()
+	start := time.Now()
+	err = lock2.WaitAcquire(ctx, ttl)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > ttl {
+		t.Errorf("WaitAcquire didn't honor the context, took %v", elapsed)
+	}
+
+	go func() {
+		time.Sleep(ttl / 3)
+		lock1.Release()
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*ttl)
+	defer cancel()
+	err = lock2.WaitAcquire(ctx, ttl)
+	if err != nil {
+		t.Fatalf("Cannot wait for lock '%s': %s", lockName, err)
+	}
+	defer lock2.Release()
+	info, err := lock2.Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: '%s'", err)
+	}
+	if info.Owner != c2.ID() || info.Data != "client2" {
+		t.Errorf("Expected lock owned by %s with data client2, got %+v", c2.ID(), info)
+	}
+}
+
+func testAcquireMulti(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	c2 := cfun(t)
+	ttl := time.Duration(ttlLength) * scale
+	names := []string{lockName + "-c", lockName + "-a", lockName + "-b", lockName + "-a"}
+
+	locks, err := c1.AcquireMulti(names, ttl)
+	if err != nil {
+		t.Fatalf("Cannot acquire locks: %s", err)
+	}
+	if len(locks) != 3 {
+		t.Fatalf("Expected 3 locks, got %d", len(locks))
+	}
+	for i, name := range []string{lockName + "-a", lockName + "-b", lockName + "-c"} {
+		info, err := locks[i].Info()
+		if err != nil || info.Name != name || info.Owner != c1.ID() {
+			t.Errorf("Expected lock %s held by %s, got %+v %v", name, c1.ID(), info, err)
+		}
+	}
+
+	// a conflict leaves no lock acquired
+	if _, err := c2.AcquireMulti([]string{lockName + "-0", lockName + "-b"}, ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+	if info, err := c2.NewLock(lockName + "-0").Info(); err != nil || info.Acquired {
+		t.Errorf("Expected partially acquired locks to be released, got %+v %v", info, err)
+	}
+
+	if err := c1.ReleaseMulti(locks); err != nil {
+		t.Fatalf("Cannot release locks: %s", err)
+	}
+	err = c1.ReleaseMulti(locks)
+	if errs, ok := err.(MultiError); !ok || len(errs) != 3 {
+		t.Errorf("Expected 3 release errors, got %v", err)
+	}
+}
+
+func testLockContext(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c := cfun(t)
+	ttl := time.Duration(ttlLength) * scale
+	lock := c.NewLock(lockName)
+
+	// a done context fails the operations
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := lock.AcquireContext(ctx, ttl); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if info, err := lock.Info(); err != nil || info.Acquired {
+		t.Errorf("Expected lock not acquired with a done context, got %+v %v", info, err)
+	}
+
+	if err := lock.AcquireContext(context.Background(), ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	if err := lock.RefreshContext(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := lock.ReleaseContext(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if err := lock.RefreshContext(context.Background()); err != nil {
+		t.Errorf("Cannot refresh lock '%s': %s", lockName, err)
+	}
+	if err := lock.ReleaseContext(context.Background()); err != nil {
+		t.Errorf("Cannot release lock '%s': %s", lockName, err)
+	}
+}
+
+func testAcquireWait(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	c2 := cfun(t)
+	ttl := time.Duration(ttlLength) * scale
+
+	lock1 := c1.NewLock(lockName)
+	lock2 := c2.NewLock(lockName)
+	if err := lock1.Acquire(ttl); err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	if err := AcquireWait(lock2, ttl, ttl/3); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient after the wait, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(ttl / 3)
+		lock1.Release()
+	}()
+	if err := AcquireWait(lock2, ttl, 10*ttl); err != nil {
+		t.Fatalf("Cannot wait for lock '%s': %s", lockName, err)
+	}
+	if err := lock2.Release(); err != nil {
+		t.Errorf("Cannot release lock '%s': %s", lockName, err)
+	}
+}
+
+func testAcquireAndKeepAlive(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	c2 := cfun(t)
+	ttl := 10 * scale
+
+	lock := c1.NewLock(lockName)
+	stop, errs, err := AcquireAndKeepAlive(lock, ttl, 0.3)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	if _, _, err := AcquireAndKeepAlive(c2.NewLock(lockName), ttl, 0.3); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+
+	// the lock outlives its TTL
+	time.Sleep(2 * ttl)
+	stop()
+	if err, ok := <-errs; ok {
+		t.Errorf("Unexpected refresh error: %v", err)
+	}
+	info, err := lock.Info()
+	if err != nil || !info.Acquired || info.Owner != c1.ID() {
+		t.Errorf("Expected lock kept alive by %s, got %+v %v", c1.ID(), info, err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Errorf("Cannot release lock '%s': %s", lockName, err)
+	}
+}
+
+// failingRefreshLock is a Lock whose refreshes fail, without the lock being
+// lost, once fail is set
+type failingRefreshLock struct {
+	Lock
+	fail chan struct{}
+}
+
+func (l *failingRefreshLock) Refresh() error {
+	select {
+	case <-l.fail:
+		return ErrConnection
+	default:
+		return l.Lock.Refresh()
+	}
+}
+
+func testAcquireCtx(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	c2 := cfun(t)
+	ttl := 10 * scale
+
+	canceled := func(ctx context.Context, within time.Duration) bool {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-time.After(within):
+			return false
+		}
+	}
+
+	// the context lives while the lock is refreshed, and is canceled by release
+	ctx, release, err := AcquireCtx(c1.NewLock(lockName), ttl)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	if _, _, err := AcquireCtx(c2.NewLock(lockName), ttl); err != ErrLockHeldByOtherClient {
+		t.Errorf("Expected ErrLockHeldByOtherClient, got %v", err)
+	}
+	if canceled(ctx, 2*ttl) {
+		t.Fatalf("Context canceled while the lock is held: %v", ctx.Err())
+	}
+	release()
+	release()
+	if !canceled(ctx, ttl) {
+		t.Fatal("Context not canceled by release")
+	}
+	if err := c2.NewLock(lockName).Acquire(ttl); err != nil {
+		t.Fatalf("Lock not released: %v", err)
+	}
+	c2.NewLock(lockName).Release()
+
+	// the context is canceled when the lock is lost
+	ctx, release, err = AcquireCtx(c1.NewLock(lockName), ttl)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	defer release()
+	if err := c1.NewLock(lockName).Release(); err != nil {
+		t.Fatalf("Cannot release lock '%s': %s", lockName, err)
+	}
+	if !canceled(ctx, ttl) {
+		t.Error("Context not canceled after the lock was lost")
+	}
+
+	// the context is canceled before the lock expires if refreshes fail
+	lock := &failingRefreshLock{Lock: c1.NewLock(lockName), fail: make(chan struct{})}
+	ctx, release, err = AcquireCtx(lock, ttl)
+	if err != nil {
+		t.Fatalf("Cannot acquire lock '%s': %s", lockName, err)
+	}
+	defer release()
+	close(lock.fail)
+	start := time.Now()
+	if !canceled(ctx, 2*ttl) {
+		t.Fatal("Context not canceled while the refreshes fail")
+	}
+	if elapsed := time.Since(start); elapsed >= ttl {
+		t.Errorf("Context canceled after the lock expired, after %s", elapsed)
+	}
+	if info, err := lock.Info(); err != nil || !info.Acquired {
+		t.Errorf("Expected the lock still held when the context is canceled, got %+v %v", info, err)
+	}
+}
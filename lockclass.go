@@ -0,0 +1,103 @@
+package glock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LockClassConfig holds the defaults applied to the locks of a class,
+// see RedisClient.DefineLockClass
+type LockClassConfig struct {
+	// TTL is used by Acquire when called with a ttl <= 0
+	TTL time.Duration
+	// MaxWait is how long Acquire waits for a lock held by another client.
+	// If 0, Acquire returns immediately
+	MaxWait time.Duration
+	// Backoff is the delay between acquisition attempts while waiting,
+	// doubled at each attempt up to MaxBackoff. Default to 10ms and 1s, the
+	// delays being reduced by the client WaitJitter
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxDataSize and OnOversizeData, if MaxDataSize > 0, override the
+	// client data size policy
+	MaxDataSize    int
+	OnOversizeData OversizePolicy
+}
+
+// lockClasses are the lock classes of a client and its clones, by name
+type lockClasses struct {
+	mtx     sync.RWMutex
+	classes map[string]LockClassConfig
+}
+
+func newLockClasses() *lockClasses {
+	return &lockClasses{classes: make(map[string]LockClassConfig)}
+}
+
+func (lc *lockClasses) get(name string) (LockClassConfig, bool) {
+	lc.mtx.RLock()
+	defer lc.mtx.RUnlock()
+	cfg, ok := lc.classes[name]
+	return cfg, ok
+}
+
+// DefineLockClass defines (or redefines) the lock class with the given name.
+// Classes are looked up on each acquisition, so redefining a class applies
+// to all the future acquisitions of its locks. Classes are shared with the
+// clients returned by Clone.
+func (c *RedisClient) DefineLockClass(name string, cfg LockClassConfig) {
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 10 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		cfg.MaxBackoff = defaultWaitMaxBackoff
+		if cfg.MaxBackoff < cfg.Backoff {
+			cfg.MaxBackoff = cfg.Backoff
+		}
+	}
+	c.classes.mtx.Lock()
+	c.classes.classes[name] = cfg
+	c.classes.mtx.Unlock()
+}
+
+// NewClassLock creates a lock using the defaults of the given class.
+// If the class is not defined, all the operations on the lock fail with
+// ErrUnknownLockClass.
+func (c *RedisClient) NewClassLock(className, lockName string) Lock {
+	l := c.newLock(lockName, "")
+	if _, ok := c.classes.get(className); !ok && l.err == nil {
+		l.err = ErrUnknownLockClass
+	}
+	l.class = className
+	return l
+}
+
+// lockClass returns the configuration of the lock class, if any
+func (l *RedisLock) lockClass() (LockClassConfig, bool) {
+	if l.class == "" {
+		return LockClassConfig{}, false
+	}
+	return l.client.classes.get(l.class)
+}
+
+// acquireClass acquires the lock applying the class defaults, retrying with
+// the class backoff up to MaxWait while the lock is held by another client
+func (l *RedisLock) acquireClass(ttl time.Duration, cfg LockClassConfig) error {
+	if ttl <= 0 {
+		ttl = cfg.TTL
+	}
+	acquire := func() error { return l.acquireAs(l.client.ID(), ttl) }
+	if cfg.MaxWait <= 0 {
+		return acquire()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.MaxWait)
+	defer cancel()
+	delay := backoffDelay(cfg.Backoff, cfg.MaxBackoff, l.client.opts.WaitJitter)
+	err := waitAcquireNotified(ctx, l.client.opts.Clock, acquire, delay, nil)
+	if err == context.DeadlineExceeded {
+		// the last attempt found the lock held
+		return ErrLockHeldByOtherClient
+	}
+	return err
+}
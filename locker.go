@@ -0,0 +1,130 @@
+package glock
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultLockerTTL is the TTL used by RedisLock.Lock, unless changed with
+// SetLockerTTL
+const DefaultLockerTTL = 30 * time.Second
+
+// SetLockerTTL sets the TTL used by Lock
+func (l *RedisLock) SetLockerTTL(ttl time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.lockerTTL = ttl
+}
+
+// Lock implements sync.Locker: it blocks until the lock is acquired for
+// DefaultLockerTTL, or the TTL set with SetLockerTTL. The lock is not
+// refreshed, so it expires if held for longer.
+// It panics if the lock cannot be acquired for any other reason than being
+// held by another client.
+func (l *RedisLock) Lock() {
+	l.mtx.Lock()
+	ttl := l.lockerTTL
+	l.mtx.Unlock()
+	if ttl == 0 {
+		ttl = DefaultLockerTTL
+	}
+	if err := l.WaitAcquire(context.Background(), ttl); err != nil {
+		panic("glock: cannot lock " + l.name + ": " + err.Error())
+	}
+}
+
+// Unlock implements sync.Locker, releasing the lock. Like sync.Mutex, it
+// panics if the lock is not held.
+func (l *RedisLock) Unlock() {
+	if err := l.Release(); err != nil {
+		panic("glock: cannot unlock " + l.name + ": " + err.Error())
+	}
+}
+
+// WithLock acquires the lock name for ttl, runs fn, and releases the lock
+// even if fn panics. It returns the error of fn, or else the one of the
+// release. fn is not run if the lock cannot be acquired.
+func (c *RedisClient) WithLock(name string, ttl time.Duration, fn func() error) (err error) {
+	lock := c.NewLock(name)
+	if err = lock.Acquire(ttl); err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := lock.Release(); err == nil {
+			err = releaseErr
+		}
+	}()
+	return fn()
+}
+
+// WithLockContext is like WithLock, refreshing the lock while fn runs, see
+// the WithLockContext function.
+func (c *RedisClient) WithLockContext(ctx context.Context, name string, ttl time.Duration,
+	fn func(ctx context.Context) error) error {
+	return WithLockContext(ctx, c, name, ttl, fn)
+}
+
+// WithLockContext acquires the lock name of c for ttl, and runs fn while
+// refreshing the lock every third of ttl. The context passed to fn is
+// canceled when ctx is done or when the lock is lost: a refresh failed with
+// ErrLockNotOwned, or the refreshes failed (i.e. on connection errors) until
+// the ttl elapsed since the last successful one. The lock is released when fn
+// returns, even if it panics.
+// It returns the error of the acquisition, or ErrLockNotOwned if the lock was
+// lost while fn was running (regardless of the error of fn, as the lock may
+// have been acquired by another client meanwhile), or else the error of fn,
+// or the one of the release. fn is not run if the lock cannot be acquired.
+func WithLockContext(ctx context.Context, c Client, name string, ttl time.Duration,
+	fn func(ctx context.Context) error) (err error) {
+	lock := c.NewLock(name)
+	start := clockOf(lock).Now()
+	if err = lock.AcquireContext(ctx, ttl); err != nil {
+		return err
+	}
+	fnCtx, cancel := context.WithCancel(ctx)
+	lost := make(chan error, 1)
+	go func() {
+		lost <- guardLock(fnCtx, lock, start.Add(ttl), ttl, cancel)
+	}()
+	defer func() {
+		cancel()
+		if lostErr := <-lost; lostErr != nil {
+			err = lostErr
+			return
+		}
+		if releaseErr := lock.Release(); err == nil {
+			err = releaseErr
+		}
+	}()
+	return fn(fnCtx)
+}
+
+// guardLock refreshes lock every third of ttl until ctx is done, calling
+// cancel and returning ErrLockNotOwned if the lock is lost or expired (at
+// expiry, unless refreshed), see WithLockContext
+func guardLock(ctx context.Context, lock Lock, expiry time.Time, ttl time.Duration, cancel func()) error {
+	clock := clockOf(lock)
+	for {
+		timer := clock.NewTimer(ttl / 3)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C():
+		}
+		// the refresh is not bound to ctx, so that it's not interrupted
+		// when fn returns
+		start := clock.Now()
+		err := lock.Refresh()
+		switch {
+		case err == nil:
+			expiry = start.Add(ttl)
+			continue
+		case err == ErrLockNotOwned || err == ErrLockOrphaned:
+		case clock.Now().Before(expiry):
+			continue
+		}
+		cancel()
+		return ErrLockNotOwned
+	}
+}
@@ -1,9 +1,10 @@
 package glock
 
 import (
-	"errors"
+	"context"
 	"io/ioutil"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/aristanetworks/goarista/monotime"
@@ -16,6 +17,33 @@ type LockManager struct {
 	client Client
 	locks  map[string]Lock
 	hb     map[string]chan error
+	stop   map[string]chan struct{}
+	health map[string]func() bool
+	dead   map[string]*deadMan
+}
+
+// deadMan is a dead man's switch, tripped when beat is not called for longer
+// than interval
+type deadMan struct {
+	mtx      sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+func newDeadMan(interval time.Duration) *deadMan {
+	return &deadMan{interval: interval, last: time.Now()}
+}
+
+func (d *deadMan) beat() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.last = time.Now()
+}
+
+func (d *deadMan) tripped() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return time.Since(d.last) > d.interval
 }
 
 // AcquireOptions allows to set options during lock acquisition.
@@ -26,6 +54,21 @@ type AcquireOptions struct {
 	MaxWait time.Duration
 	// The Data to set with the lock.
 	Data string
+	// NoWait makes Acquire fail immediately if the lock is held by another
+	// client, regardless of the MaxWait configured on the manager.
+	NoWait bool
+	// Healthy, if set, is called before each heartbeat: if it returns false
+	// the heartbeat stops with ErrLockUnhealthy, leaving the lock to expire.
+	Healthy func() bool
+	// OnAcquired, if set, is called when Acquire acquires a lock, with the
+	// number of attempts (1 if the lock was acquired immediately) and the
+	// time spent waiting for it
+	OnAcquired func(lockName string, attempts int, waited time.Duration)
+	// DeadManInterval, if > 0, requires the application to call Heartbeat on
+	// the manager at least once per interval while the lock is heartbeating:
+	// if it doesn't, the lock is released and the heartbeat stops with
+	// ErrHeartbeatMissed, even if the lock TTL has not expired yet.
+	DeadManInterval time.Duration
 }
 
 // NewLockManager returns a new LockManager for the given client.
@@ -38,6 +81,9 @@ func NewLockManager(client Client, opts AcquireOptions) *LockManager {
 		client,
 		make(map[string]Lock),
 		make(map[string]chan error),
+		make(map[string]chan struct{}),
+		make(map[string]func() bool),
+		make(map[string]*deadMan),
 	}
 }
 
@@ -71,6 +117,17 @@ func (m *LockManager) Info(lockName string) (*LockInfo, error) {
 	return info, nil
 }
 
+// Heartbeat reports that the application holding the lock is alive, see
+// AcquireOptions.DeadManInterval.
+func (m *LockManager) Heartbeat(lockName string) error {
+	d, ok := m.dead[lockName]
+	if !ok {
+		return ErrInvalidLock
+	}
+	d.beat()
+	return nil
+}
+
 // Refresh refreshes a single lock.
 func (m *LockManager) Refresh(lockName string) error {
 	lock, ok := m.locks[lockName]
@@ -93,12 +150,18 @@ func (m *LockManager) acquire(lockName string, opts AcquireOptions) error {
 	}
 	m.Logger.Printf("client %s: Acquired lock '%s' for %v", m.client.ID(), lockName, opts.TTL)
 	m.locks[lockName] = lock
+	if opts.Healthy != nil {
+		m.health[lockName] = opts.Healthy
+	}
+	if opts.DeadManInterval > 0 {
+		m.dead[lockName] = newDeadMan(opts.DeadManInterval)
+	}
 	return nil
 }
 
 // Acquire tries to acquire the lock with the given name using the default TTL
 // for the manager. If the lock cannot be acquired, it will wait up to MaxWait
-// for the lock to be released by the owner.
+// for the lock to be released by the owner, unless NoWait is set.
 // If this manager instance already has acquired this lock, this action is a no-op.
 func (m *LockManager) Acquire(lockName string, opts AcquireOptions) error {
 
@@ -113,7 +176,21 @@ func (m *LockManager) Acquire(lockName string, opts AcquireOptions) error {
 		opts.Data = m.opts.Data
 	}
 
-	if opts.MaxWait <= 0 {
+	if opts.Healthy == nil {
+		opts.Healthy = m.opts.Healthy
+	}
+
+	if opts.OnAcquired == nil {
+		opts.OnAcquired = m.opts.OnAcquired
+	}
+
+	if opts.DeadManInterval <= 0 {
+		opts.DeadManInterval = m.opts.DeadManInterval
+	}
+
+	if opts.NoWait {
+		opts.MaxWait = 0
+	} else if opts.MaxWait <= 0 {
 		opts.MaxWait = m.opts.MaxWait
 	}
 
@@ -122,10 +199,13 @@ func (m *LockManager) Acquire(lockName string, opts AcquireOptions) error {
 		return lock.RefreshTTL(opts.TTL)
 	}
 
-	for {
+	for attempts := 1; ; attempts++ {
 		init := monotime.Now()
 		err := m.acquire(lockName, opts)
 		if err == nil {
+			if opts.OnAcquired != nil {
+				opts.OnAcquired(lockName, attempts, waited)
+			}
 			return nil
 		}
 		if err != ErrLockHeldByOtherClient {
@@ -143,6 +223,10 @@ func (m *LockManager) Acquire(lockName string, opts AcquireOptions) error {
 		}
 
 		wait := info.TTL - monotime.Since(init)
+		if wait < 0 {
+			// the lock is expiring
+			wait = 0
+		}
 		if waited+wait > opts.MaxWait {
 			wait = opts.MaxWait - waited
 		}
@@ -161,6 +245,8 @@ func (m *LockManager) Release(lockName string) error {
 		m.StopHeartbeat(lockName)
 		err = lock.Release()
 		delete(m.locks, lockName)
+		delete(m.health, lockName)
+		delete(m.dead, lockName)
 	}
 	return err
 }
@@ -178,7 +264,9 @@ func (m *LockManager) ReleaseAll() map[string]error {
 	return results
 }
 
-func heartbeat(client Client, logger *log.Logger, lockName string, ttl time.Duration, control chan error) {
+func heartbeat(client Client, logger *log.Logger, lockName string, ttl time.Duration,
+	control chan<- error, stop <-chan struct{}, beats chan<- time.Time, healthy func() bool,
+	dead *deadMan) {
 	client.Reconnect()
 	defer client.Close()
 	freq := time.Duration(ttl / 2)
@@ -191,25 +279,48 @@ func heartbeat(client Client, logger *log.Logger, lockName string, ttl time.Dura
 	lock := client.NewLock(lockName)
 	for {
 		select {
-		case <-control:
+		case <-stop:
 			return
 
 		default:
+			if dead != nil && dead.tripped() {
+				logger.Printf("client %s: heartbeat -- application heartbeat missed, releasing lock '%s'",
+					client.ID(), lockName)
+				lock.Release()
+				select {
+				case control <- ErrHeartbeatMissed:
+				case <-stop:
+				}
+				return
+			}
 			if elapsed >= freq {
 				start := time.Now()
-				err := lock.RefreshTTL(ttl)
+				var err error
+				if healthy != nil && !healthy() {
+					err = ErrLockUnhealthy
+				} else {
+					err = lock.RefreshTTL(ttl)
+				}
 				if err != nil {
 					logger.Printf("client %s: heartbeat -- FATAL cannot refresh lock '%s': %s",
 						client.ID(), lockName, err.Error())
 					select {
 					case control <- err:
 						return
+					case <-stop:
+						return
 					case <-time.After(sleeptime):
 						panic(err)
 					}
 				}
 				logger.Printf("client %s: heartbeat -- refreshed lock '%s' for %v",
 					client.ID(), lockName, ttl)
+				if beats != nil {
+					select {
+					case beats <- start:
+					default:
+					}
+				}
 				s := sleeptime - (time.Now().Sub(start))
 				time.Sleep(s)
 				elapsed = s
@@ -228,24 +339,84 @@ func heartbeat(client Client, logger *log.Logger, lockName string, ttl time.Dura
 // called or the lock released.  It will return a channel to signal if the lock
 // cannot be refreshed during heartbeats (before panicking)
 func (m *LockManager) StartHeartbeat(lockName string) (<-chan error, error) {
+	control, _, err := m.startHeartbeat(lockName, nil)
+	return control, err
+}
+
+func (m *LockManager) startHeartbeat(lockName string, beats chan<- time.Time) (<-chan error, time.Duration, error) {
 	info, err := m.Info(lockName)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	m.Logger.Printf("client %s: Starting heartbeats for lock '%s' every %v", m.client.ID(),
 		lockName, info.TTL/2)
 	m.hb[lockName] = make(chan error)
-	go heartbeat(m.client.Clone(), m.Logger, lockName, info.TTL, m.hb[lockName])
-	return m.hb[lockName], nil
+	m.stop[lockName] = make(chan struct{})
+	go heartbeat(m.client.Clone(), m.Logger, lockName, info.TTL, m.hb[lockName], m.stop[lockName], beats,
+		m.health[lockName], m.dead[lockName])
+	return m.hb[lockName], info.TTL, nil
+}
+
+// AcquireCtx acquires the lock like Acquire and starts heartbeating it.
+// It returns a context that is canceled as soon as the lock is lost (a
+// heartbeat fails), released, or about to expire because heartbeats did not
+// refresh it in time. The returned cancel function releases the lock and
+// cancels the context; it is safe to call it more than once.
+func (m *LockManager) AcquireCtx(lockName string, opts AcquireOptions) (context.Context, func(), error) {
+	err := m.Acquire(lockName, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	beats := make(chan time.Time, 1)
+	control, ttl, err := m.startHeartbeat(lockName, beats)
+	if err != nil {
+		m.Release(lockName)
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	margin := ttl - ttl/10
+	go func() {
+		defer cancel()
+		timer := time.NewTimer(margin)
+		defer timer.Stop()
+		for {
+			select {
+			case <-beats:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(margin)
+			case <-timer.C:
+				m.Logger.Printf("client %s: lock '%s' is about to expire, canceling context",
+					m.client.ID(), lockName)
+				return
+			case <-control:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			m.Release(lockName)
+			cancel()
+		})
+	}
+	return ctx, release, nil
 }
 
 // StopHeartbeat will stop the background gororoutine, if any, that is heartbeating the given lock
 func (m *LockManager) StopHeartbeat(lockName string) {
-	if c, ok := m.hb[lockName]; ok {
+	if c, ok := m.stop[lockName]; ok {
 		m.Logger.Printf("client %s: Stopping heartbeats for lock '%s'", m.client.ID(),
 			lockName)
-		c <- errors.New("")
 		close(c)
+		delete(m.stop, lockName)
 		delete(m.hb, lockName)
 	}
 }
@@ -70,7 +70,7 @@ func testManagerAcquireWait(t *testing.T, cfun newClientFunc, scale time.Duratio
 		err2 = m2.Acquire(lockName, options(scale, ttl, 4*ttl, defData))
 	}()
 
-	time.Sleep(time.Duration(3 * ttl))
+	time.Sleep(time.Duration(3*ttl) * scale)
 	err = m1.Release(lockName)
 	wg.Wait()
 
@@ -115,9 +115,9 @@ func testManagerFailReleaseAll(t *testing.T, cfun newClientFunc, scale time.Dura
 	// Manager has a reference to an 'acquired' lock which is not owned anymore
 	errors := m1.ReleaseAll()
 	expected := map[string]error{
-		lockName: ErrLockNotOwned,
+		lockName: ErrLockExpired,
 	}
-	if len(errors) != 1 || errors[lockName] != ErrLockNotOwned {
+	if len(errors) != 1 || errors[lockName] != ErrLockExpired {
 		t.Fatalf("errors: expected %+v, got %+v", expected, errors)
 	}
 }
@@ -187,3 +187,230 @@ func testManagerAcquire(t *testing.T, cfun newClientFunc, scale time.Duration) {
 		t.Fatalf("Info on non-existing lock should return '%s', got '%s'", ErrInvalidLock, err)
 	}
 }
+
+func testManagerAcquireCtx(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	m1 := NewLockManager(c1, options(scale, ttlLength, 0, defData))
+	ttl := 100 * scale
+
+	ctx, cancel, err := m1.AcquireCtx(lockName, AcquireOptions{TTL: ttl})
+	if err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+
+	// heartbeats should keep the lock, and the context, alive past the ttl
+	select {
+	case <-ctx.Done():
+		t.Fatalf("Context canceled while the lock is held: %s", ctx.Err())
+	case <-time.After(2 * ttl):
+	}
+
+	cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(ttl):
+		t.Fatal("Context not canceled after release")
+	}
+	cancel()
+
+	st, err := c1.NewLock(lockName).Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: '%s'", err)
+	}
+	if st.Acquired {
+		t.Fatalf("Lock should have been released by cancel, info: %+v", st)
+	}
+
+	// Losing the lock from under the manager must cancel the context
+	ctx, cancel, err = m1.AcquireCtx(lockName, AcquireOptions{TTL: ttl})
+	if err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	defer cancel()
+
+	err = c1.NewLock(lockName).Release()
+	if err != nil {
+		t.Fatalf("Error in release: %s", err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * ttl):
+		t.Fatal("Context not canceled after the lock was lost")
+	}
+}
+
+func testManagerAcquireNoWait(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	ttl := 100
+	opts := options(scale, ttl, 10*ttl, defData)
+	m1 := NewLockManager(cfun(t), opts)
+	m2 := NewLockManager(cfun(t), opts)
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+
+	err := m1.Acquire(lockName, AcquireOptions{TTL: time.Duration(2*ttl) * scale})
+	if err != nil {
+		t.Fatalf("Cannot acquire lock: '%s'", err)
+	}
+
+	// NoWait overrides the manager MaxWait and fails fast
+	start := time.Now()
+	err = m2.Acquire(lockName, AcquireOptions{NoWait: true})
+	if err != ErrLockHeldByOtherClient {
+		t.Errorf("In acquire: expected '%s', got '%s'", ErrLockHeldByOtherClient, err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Duration(ttl)*scale {
+		t.Errorf("Acquire with NoWait should not wait, took %v", elapsed)
+	}
+
+	// Without NoWait the same manager waits for the lock to be released
+	released := make(chan error)
+	go func() {
+		time.Sleep(time.Duration(ttl) * scale)
+		released <- m1.Release(lockName)
+	}()
+	err = m2.Acquire(lockName, AcquireOptions{})
+	if rerr := <-released; rerr != nil {
+		t.Errorf("Error in release: %s", rerr)
+	}
+	if err != nil {
+		t.Fatalf("Error in acquire for manager2: '%s'", err)
+	}
+}
+
+func testManagerHealthyHeartbeat(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	m1 := NewLockManager(c1, options(scale, ttlLength, 0, defData))
+	defer m1.ReleaseAll()
+	ttl := 100 * scale
+
+	var mu sync.Mutex
+	healthy := true
+	isHealthy := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return healthy
+	}
+	err := m1.Acquire(lockName, AcquireOptions{TTL: ttl, Healthy: isHealthy})
+	if err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	control, err := m1.StartHeartbeat(lockName)
+	if err != nil {
+		t.Fatalf("Cannot start heartbeat: %s", err)
+	}
+
+	// a healthy holder keeps the lock past the ttl
+	time.Sleep(2 * ttl)
+	st, err := c1.NewLock(lockName).Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: '%s'", err)
+	}
+	if !st.Acquired {
+		t.Fatalf("Lock not refreshed while healthy, info: %+v", st)
+	}
+
+	mu.Lock()
+	healthy = false
+	mu.Unlock()
+	select {
+	case err = <-control:
+		if err != ErrLockUnhealthy {
+			t.Errorf("Expected error '%s', got '%v'", ErrLockUnhealthy, err)
+		}
+	case <-time.After(ttl):
+		t.Fatal("Heartbeat not stopped after the holder became unhealthy")
+	}
+
+	// the lock is not refreshed anymore and expires
+	time.Sleep(ttl)
+	st, err = c1.NewLock(lockName).Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: '%s'", err)
+	}
+	if st.Acquired {
+		t.Fatalf("Lock refreshed while unhealthy, info: %+v", st)
+	}
+}
+
+func testManagerOnAcquired(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	ttl := 100
+	var attempts int
+	var waited time.Duration
+	opts := options(scale, ttl, 10*ttl, defData)
+	opts.OnAcquired = func(name string, n int, d time.Duration) {
+		if name != lockName {
+			t.Errorf("Unexpected lock name %s", name)
+		}
+		attempts, waited = n, d
+	}
+	m1 := NewLockManager(cfun(t), opts)
+	m2 := NewLockManager(cfun(t), opts)
+	defer m1.ReleaseAll()
+	defer m2.ReleaseAll()
+
+	if err := m1.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	if attempts != 1 || waited != 0 {
+		t.Errorf("Expected an immediate acquisition, got %d attempts after %v", attempts, waited)
+	}
+
+	// m2 waits for the lock to expire
+	if err := m2.Acquire(lockName, AcquireOptions{}); err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	if attempts < 2 || waited <= 0 {
+		t.Errorf("Expected an acquisition after waiting, got %d attempts after %v", attempts, waited)
+	}
+}
+
+func testManagerDeadManSwitch(t *testing.T, cfun newClientFunc, scale time.Duration) {
+	c1 := cfun(t)
+	m1 := NewLockManager(c1, options(scale, ttlLength, 0, defData))
+	defer m1.ReleaseAll()
+	ttl := 500 * scale
+	interval := 50 * scale
+
+	if err := m1.Heartbeat(lockName); err != ErrInvalidLock {
+		t.Errorf("Expected error '%s', got '%v'", ErrInvalidLock, err)
+	}
+	err := m1.Acquire(lockName, AcquireOptions{TTL: ttl, DeadManInterval: interval})
+	if err != nil {
+		t.Fatalf("Cannot acquire lock: %s", err)
+	}
+	control, err := m1.StartHeartbeat(lockName)
+	if err != nil {
+		t.Fatalf("Cannot start heartbeat: %s", err)
+	}
+
+	// the lock is kept while the application heartbeats
+	for i := 0; i < 10; i++ {
+		time.Sleep(interval / 2)
+		if err := m1.Heartbeat(lockName); err != nil {
+			t.Fatalf("Error in Heartbeat: %s", err)
+		}
+	}
+	select {
+	case err = <-control:
+		t.Fatalf("Heartbeat stopped while the application is alive: %s", err)
+	default:
+	}
+
+	// missing heartbeats releases the lock well before its ttl
+	select {
+	case err = <-control:
+		if err != ErrHeartbeatMissed {
+			t.Errorf("Expected error '%s', got '%v'", ErrHeartbeatMissed, err)
+		}
+	case <-time.After(ttl / 2):
+		t.Fatal("Lock not released after missing heartbeats")
+	}
+	st, err := c1.NewLock(lockName).Info()
+	if err != nil {
+		t.Fatalf("Error while getting lock info: '%s'", err)
+	}
+	if st.Acquired {
+		t.Fatalf("Lock should have been released, info: %+v", st)
+	}
+}
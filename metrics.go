@@ -0,0 +1,111 @@
+package glock
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	metricsOnce sync.Once
+	metrics     *expvar.Map
+	counters    = map[string]string{
+		EventAcquire: "acquires",
+		EventRelease: "releases",
+		EventRefresh: "refreshes",
+	}
+)
+
+// PublishedMetrics returns the expvar map published as "glock".
+// It contains the acquires, releases, refreshes and failures counters, and
+// held, the number of locks currently acquired and not yet released by this
+// process (locks silently expiring in the store are not accounted for).
+// The map is published the first time it is needed, either by calling this
+// function or by using a client with metrics enabled.
+func PublishedMetrics() *expvar.Map {
+	metricsOnce.Do(func() {
+		metrics = expvar.NewMap("glock")
+	})
+	return metrics
+}
+
+func recordEvent(op string, err error) {
+	m := PublishedMetrics()
+	if err != nil {
+		m.Add("failures", 1)
+		return
+	}
+	m.Add(counters[op], 1)
+	switch op {
+	case EventAcquire:
+		m.Add("held", 1)
+	case EventRelease:
+		m.Add("held", -1)
+	}
+}
+
+// Metrics receives measurements of the lock operations of a RedisClient,
+// see RedisOptions.Metrics. Its methods are called synchronously by the
+// operations, possibly from multiple goroutines, and must not block.
+// See the glockprom package for a Prometheus implementation.
+type Metrics interface {
+	// Acquired is called after each acquisition attempt, err being
+	// ErrLockHeldByOtherClient if the lock was contended
+	Acquired(name string, dur time.Duration, err error)
+	// Released is called after each release
+	Released(name string, dur time.Duration, err error)
+	// Refreshed is called after each refresh, err being ErrLockNotOwned if
+	// the lock was lost
+	Refreshed(name string, dur time.Duration, err error)
+	// Held is called when a lock acquired by the client is released, with
+	// how long it was held
+	Held(name string, held time.Duration)
+	// Command is called after each redis command run by the client, with
+	// its latency. Commands sent in pipelines are not reported.
+	Command(cmd string, dur time.Duration, err error)
+}
+
+// observe reports an operation recorded by RedisClient.emit to m
+func observe(m Metrics, op, name string, dur time.Duration, err error) {
+	switch op {
+	case EventAcquire:
+		m.Acquired(name, dur, err)
+	case EventRelease:
+		m.Released(name, dur, err)
+	case EventRefresh, EventLost:
+		m.Refreshed(name, dur, err)
+	}
+}
+
+// meteredConn reports the latency of the commands to Metrics
+type meteredConn struct {
+	redis.Conn
+	metrics Metrics
+}
+
+func (c meteredConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := c.Conn.Do(cmd, args...)
+	c.observe(cmd, start, err)
+	return reply, err
+}
+
+func (c meteredConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+	c.observe(cmd, start, err)
+	return reply, err
+}
+
+func (c meteredConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+// observe reports a command, unless it only flushed the pipeline
+func (c meteredConn) observe(cmd string, start time.Time, err error) {
+	if cmd != "" {
+		c.metrics.Command(cmd, time.Since(start), err)
+	}
+}
@@ -0,0 +1,246 @@
+package glock
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisPipeline queues operations on the locks of a RedisClient, sent in a
+// single round trip by Exec, i.e. to manage many fine-grained locks at once.
+// The operations are not atomic: each one succeeds or fails on its own, as
+// if run alone. A pipeline is not safe for concurrent use.
+type RedisPipeline struct {
+	client *RedisClient
+	ops    []*PipelineOp
+}
+
+// PipelineOp is an operation queued in a RedisPipeline. Its result is
+// available once Exec returned.
+type PipelineOp struct {
+	lock *RedisLock
+	// op is one of the Event* operations, or "info"
+	op   string
+	ttl  time.Duration
+	ms   int
+	data string
+	cmds []pipelineCmd
+	info *LockInfo
+	err  error
+}
+
+// Pipeline returns a new, empty pipeline of operations on the locks of this
+// client
+func (c *RedisClient) Pipeline() *RedisPipeline {
+	return &RedisPipeline{client: c}
+}
+
+// Acquire queues the acquisition of l for ttl, like l.Acquire. The lock
+// classes (see NewClassLock) are not applied to these acquisitions.
+func (p *RedisPipeline) Acquire(l *RedisLock, ttl time.Duration) *PipelineOp {
+	return p.queue(&PipelineOp{lock: l, op: EventAcquire, ttl: ttl})
+}
+
+// Release queues the release of l, like l.Release
+func (p *RedisPipeline) Release(l *RedisLock) *PipelineOp {
+	return p.queue(&PipelineOp{lock: l, op: EventRelease})
+}
+
+// Info queues reading the information about l, like l.Info. It's read from
+// the master, even if ReadAddress is set, and InfoRetries is not applied.
+func (p *RedisPipeline) Info(l *RedisLock) *PipelineOp {
+	return p.queue(&PipelineOp{lock: l, op: "info"})
+}
+
+func (p *RedisPipeline) queue(op *PipelineOp) *PipelineOp {
+	p.ops = append(p.ops, op)
+	return op
+}
+
+// Len returns the number of operations queued
+func (p *RedisPipeline) Len() int {
+	return len(p.ops)
+}
+
+// Exec sends the operations queued in a single pipeline, emptying it, and
+// records the result of each operation, see PipelineOp.Err. The returned
+// error is not nil only if the pipeline itself failed: the operations sent
+// then fail with the same error.
+func (p *RedisPipeline) Exec() error {
+	ops := p.ops
+	p.ops = nil
+	var sent []*PipelineOp
+	var cmds []pipelineCmd
+	for _, op := range ops {
+		op.lock.mtx.Lock()
+		op.err = op.prepare()
+		op.lock.mtx.Unlock()
+		if op.err != nil {
+			continue
+		}
+		sent = append(sent, op)
+		cmds = append(cmds, op.cmds...)
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	c := p.client
+	start, began := time.Now(), monotime.Now()
+	conn := c.get()
+	replies, errs, err := sendPipeline(conn, "pipeline", cmds)
+	c.put(conn)
+	next := 0
+	for _, op := range sent {
+		if err != nil {
+			op.err = err
+		} else {
+			// the result of an operation is the reply of its last command
+			next += len(op.cmds)
+			op.lock.mtx.Lock()
+			op.finish(replies[next-1], errs[next-1], began)
+			op.lock.mtx.Unlock()
+		}
+		if op.op != "info" {
+			c.emit(op.op, op.lock.name, start, op.err)
+		}
+	}
+	return err
+}
+
+// prepare checks the operation and builds its commands
+func (op *PipelineOp) prepare() error {
+	l := op.lock
+	if l.err != nil {
+		return l.err
+	}
+	switch op.op {
+	case EventAcquire:
+		ttl, err := l.client.checkTTL(op.ttl)
+		if err != nil {
+			return err
+		}
+		l.ttl = ttl
+		l.persistent = false
+		op.ms = int(ttl.Nanoseconds() / int64(time.Millisecond))
+		args, data, err := l.acquireArgs(l.client.ID(), op.ms, false)
+		if err != nil {
+			return err
+		}
+		op.data = data
+		op.cmds = []pipelineCmd{{script: acquireScript, args: args}}
+	case EventRelease:
+		if l.orphaned() {
+			return ErrLockOrphaned
+		}
+		op.cmds = []pipelineCmd{l.releaseCmd(l.client.ID())}
+	default:
+		op.cmds = append([]pipelineCmd{{name: "MULTI"}}, l.infoCmds()...)
+		op.cmds = append(op.cmds, pipelineCmd{name: "EXEC"})
+	}
+	return nil
+}
+
+// finish records the result of the operation given the reply of its last
+// command, sent at began
+func (op *PipelineOp) finish(reply interface{}, err error, began uint64) {
+	l := op.lock
+	switch op.op {
+	case EventAcquire:
+		if err != nil {
+			op.err = opError(EventAcquire, "EVALSHA", err)
+			return
+		}
+		_, op.err = l.acquireReply(reply, false, false, op.data)
+		if op.err == nil {
+			op.err = l.acquired(l.client.ID(), began, op.ms)
+		}
+	case EventRelease:
+		res, err := redis.Int(reply, err)
+		op.err = l.released(res, err)
+	default:
+		op.info, op.err = l.infoReply(l.infoCmds(), reply, err)
+	}
+}
+
+// Err returns the error of the operation, nil if it succeeded or if the
+// pipeline was not executed yet
+func (op *PipelineOp) Err() error {
+	return op.err
+}
+
+// Info returns the information read by an Info operation
+func (op *PipelineOp) Info() (*LockInfo, error) {
+	return op.info, op.err
+}
+
+// pipelineCmd is a command sent by sendPipeline: the script with its keys
+// and arguments if script is set, the command name with its arguments
+// otherwise
+type pipelineCmd struct {
+	script *redis.Script
+	name   string
+	args   []interface{}
+}
+
+// send sends the command on conn, running a script with EVAL if eval is
+// true, with EVALSHA otherwise. It returns the name of the command sent.
+func (cmd pipelineCmd) send(conn redis.Conn, eval bool) (string, error) {
+	switch {
+	case cmd.script == nil:
+		return cmd.name, conn.Send(cmd.name, cmd.args...)
+	case eval:
+		return "EVAL", cmd.script.Send(conn, cmd.args...)
+	}
+	return "EVALSHA", cmd.script.SendHash(conn, cmd.args...)
+}
+
+// sendPipeline sends cmds on conn in a single pipeline, returning the reply
+// and the error of each. The scripts are run with EVALSHA, since they are
+// loaded on connect (see loadScripts): the ones not found in the script
+// cache of the server, i.e. after a SCRIPT FLUSH, are sent again with EVAL,
+// as Script.Do does. The returned error, an OpError of op, is not nil only
+// if the pipeline itself failed.
+func sendPipeline(conn redis.Conn, op string, cmds []pipelineCmd) ([]interface{}, []error, error) {
+	replies := make([]interface{}, len(cmds))
+	errs := make([]error, len(cmds))
+	pending := make([]int, len(cmds))
+	for i := range pending {
+		pending[i] = i
+	}
+	for _, eval := range []bool{false, true} {
+		var name string
+		for _, i := range pending {
+			var err error
+			if name, err = cmds[i].send(conn, eval); err != nil {
+				return nil, nil, opError(op, name, err)
+			}
+		}
+		if err := conn.Flush(); err != nil {
+			return nil, nil, opError(op, name, err)
+		}
+		var missing []int
+		for _, i := range pending {
+			replies[i], errs[i] = conn.Receive()
+			if _, ok := errs[i].(redis.Error); !ok && errs[i] != nil {
+				return nil, nil, opError(op, name, errs[i])
+			}
+			if cmds[i].script != nil && isNoScript(errs[i]) {
+				missing = append(missing, i)
+			}
+		}
+		if pending = missing; len(pending) == 0 {
+			break
+		}
+	}
+	return replies, errs, nil
+}
+
+// isNoScript tells if err is the NOSCRIPT error of an EVALSHA of a script
+// not in the script cache of the server
+func isNoScript(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(e), "NOSCRIPT ")
+}
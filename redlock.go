@@ -0,0 +1,512 @@
+package glock
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aristanetworks/goarista/monotime"
+	"github.com/gocql/gocql"
+)
+
+// RedlockOptions configures a RedlockClient
+type RedlockOptions struct {
+	// ClientID is the client ID used on all the nodes. If not set, a random
+	// UUID is used
+	ClientID string
+	// DriftFactor is the fraction of the TTL subtracted from the validity of
+	// the locks to account for the clock drift between the nodes.
+	// Defaults to 0.01
+	DriftFactor float64
+	// RetryInterval is the minimum time between the reconnections of a node
+	// that is down: the operations reconnect to the nodes down since at
+	// least RetryInterval before running, the others skip them.
+	// Defaults to 1 second
+	RetryInterval time.Duration
+}
+
+// RedlockClient implements the Client interface with the Redlock algorithm:
+// locks are acquired on a quorum (N/2+1) of N independent redis masters, so
+// that they survive the failure of a minority of them.
+// See https://redis.io/topics/distlock
+// Like RedisClient, it is safe for concurrent use.
+type RedlockClient struct {
+	// mtx guards id, up, retryAt and healthy
+	mtx   sync.RWMutex
+	id    string
+	nodes []*RedisClient
+	up    []bool
+	// retryAt is the monotonic time after which a node down is reconnected
+	retryAt []uint64
+	opts    RedlockOptions
+	// healthy tells if a quorum of nodes was reached by the last operation
+	healthy bool
+}
+
+// RedlockLock implements the Lock interface for locks acquired with the
+// Redlock algorithm. It is safe for concurrent use.
+type RedlockLock struct {
+	mtx        sync.Mutex
+	name       string
+	client     *RedlockClient
+	locks      []*RedisLock
+	ttl        time.Duration
+	validUntil uint64
+}
+
+// NewRedlockClient returns a new RedlockClient for the given independent
+// redis nodes. The ClientID of the nodes options is replaced by the one of
+// opts. It returns an error if less than a quorum of nodes can be reached.
+// As the algorithm requires, the commands on each node should time out
+// quickly compared to the TTL of the locks, so that an unreachable node
+// doesn't consume their validity: see RedisOptions.CommandTimeout.
+func NewRedlockClient(nodes []RedisOptions, opts RedlockOptions) (*RedlockClient, error) {
+	if len(nodes) == 0 {
+		return nil, ErrNoQuorum
+	}
+	if opts.ClientID == "" {
+		id, err := gocql.RandomUUID()
+		if err != nil {
+			return nil, err
+		}
+		opts.ClientID = id.String()
+	}
+	if opts.DriftFactor <= 0 {
+		opts.DriftFactor = 0.01
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+	c := &RedlockClient{id: opts.ClientID, opts: opts, up: make([]bool, len(nodes)), retryAt: make([]uint64, len(nodes))}
+	for _, nodeOpts := range nodes {
+		nodeOpts.ClientID = opts.ClientID
+		node, err := buildRedisClient(nodeOpts)
+		if err != nil {
+			return nil, err
+		}
+		c.nodes = append(c.nodes, node)
+	}
+	err := c.Reconnect()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// quorum returns the number of nodes needed to hold a lock
+func (c *RedlockClient) quorum() int {
+	return len(c.nodes)/2 + 1
+}
+
+// ID implements the Client interface
+func (c *RedlockClient) ID() string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.id
+}
+
+// SetID implements the Client interface
+func (c *RedlockClient) SetID(id string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.id = id
+	for _, node := range c.nodes {
+		node.SetID(id)
+	}
+}
+
+// Reconnect implements the Client interface, reconnecting to all the nodes.
+// It returns an error only if less than a quorum of nodes can be reached.
+func (c *RedlockClient) Reconnect() error {
+	connected := 0
+	var lastErr error
+	for i, node := range c.nodes {
+		err := node.Reconnect()
+		c.mtx.Lock()
+		c.up[i] = err == nil
+		c.retryAt[i] = monotime.Now() + uint64(c.opts.RetryInterval)
+		c.mtx.Unlock()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		connected++
+	}
+	c.mtx.Lock()
+	c.healthy = connected >= c.quorum()
+	c.mtx.Unlock()
+	if connected < c.quorum() {
+		return lastErr
+	}
+	return nil
+}
+
+// QuorumHealthy tells if a quorum of nodes was reached by the last operation
+// of the client, i.e. whether the locks held can still be refreshed. Nodes
+// failing with a connection error are not counted, see ErrConnection.
+func (c *RedlockClient) QuorumHealthy() bool {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.healthy
+}
+
+// Close implements the Client interface
+func (c *RedlockClient) Close() {
+	for _, node := range c.nodes {
+		node.Close()
+	}
+}
+
+// Clone implements the Client interface. The nodes of the clone are
+// connected by its first operation, or by Reconnect.
+func (c *RedlockClient) Clone() Client {
+	clone := &RedlockClient{id: c.ID(), opts: c.opts, up: make([]bool, len(c.nodes)), retryAt: make([]uint64, len(c.nodes))}
+	for _, node := range c.nodes {
+		clone.nodes = append(clone.nodes, node.Clone().(*RedisClient))
+	}
+	return clone
+}
+
+// NewLock implements the Client interface
+func (c *RedlockClient) NewLock(name string) Lock {
+	l := &RedlockLock{name: name, client: c}
+	for _, node := range c.nodes {
+		l.locks = append(l.locks, node.NewLock(name).(*RedisLock))
+	}
+	return l
+}
+
+// AcquireMulti implements the Client interface
+func (c *RedlockClient) AcquireMulti(names []string, ttl time.Duration) ([]Lock, error) {
+	return acquireMulti(c, names, ttl)
+}
+
+// ReleaseMulti implements the Client interface
+func (c *RedlockClient) ReleaseMulti(locks []Lock) error {
+	return releaseMulti(locks)
+}
+
+// fanOut runs op on the lock of every node in parallel, returning the
+// results by node. The nodes down are reconnected first if they are due for
+// a retry (see RedlockOptions.RetryInterval), and fail with ErrNoQuorum
+// otherwise. The nodes failing with a connection error are marked down. The
+// nodes reached update the health of the client, see QuorumHealthy.
+func (l *RedlockLock) fanOut(op func(i int, lock *RedisLock) error) []error {
+	c := l.client
+	errs := make([]error, len(l.locks))
+	now := monotime.Now()
+	retry := make([]bool, len(l.locks))
+	c.mtx.Lock()
+	up := append([]bool(nil), c.up...)
+	for i := range up {
+		if !up[i] && now >= c.retryAt[i] {
+			// reserved, so that concurrent operations don't reconnect too
+			retry[i] = true
+			c.retryAt[i] = now + uint64(c.opts.RetryInterval)
+		}
+	}
+	c.mtx.Unlock()
+	var wg sync.WaitGroup
+	for i, lock := range l.locks {
+		if !up[i] && !retry[i] {
+			errs[i] = ErrNoQuorum
+			continue
+		}
+		wg.Add(1)
+		go func(i int, lock *RedisLock) {
+			defer wg.Done()
+			if retry[i] {
+				// a single attempt, not to consume the validity of the lock
+				if err := c.nodes[i].connect(); err != nil {
+					errs[i] = ErrNoQuorum
+					return
+				}
+				up[i] = true
+			}
+			errs[i] = op(i, lock)
+		}(i, lock)
+	}
+	wg.Wait()
+	reached := 0
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for i, err := range errs {
+		switch {
+		case !up[i]:
+		case errors.Is(err, ErrConnection):
+			c.up[i] = false
+			c.retryAt[i] = now
+		default:
+			if retry[i] {
+				c.up[i] = true
+			}
+			reached++
+		}
+	}
+	c.healthy = reached >= c.quorum()
+	return errs
+}
+
+// quorumErr returns nil if op succeeded on a quorum of nodes. Otherwise
+// it returns notHeld if the lock is held by another client on any node,
+// ErrNoQuorum if not.
+func (l *RedlockLock) quorumErr(errs []error, notHeld error) error {
+	ok := 0
+	held := false
+	for _, err := range errs {
+		switch err {
+		case nil:
+			ok++
+		case notHeld:
+			held = true
+		}
+	}
+	switch {
+	case ok >= l.client.quorum():
+		return nil
+	case held:
+		return notHeld
+	}
+	return ErrNoQuorum
+}
+
+// drift returns the clock drift allowance for ttl
+func (l *RedlockLock) drift(ttl time.Duration) time.Duration {
+	return time.Duration(float64(ttl)*l.client.opts.DriftFactor) + 2*time.Millisecond
+}
+
+// hold checks that the operation started at start and with errs succeeded on
+// a quorum within the validity window, updating it.
+func (l *RedlockLock) hold(start uint64, errs []error, notHeld error) error {
+	err := l.quorumErr(errs, notHeld)
+	validity := l.ttl - monotime.Since(start) - l.drift(l.ttl)
+	if err == nil && validity <= 0 {
+		err = ErrNoQuorum
+	}
+	if err != nil {
+		l.validUntil = 0
+		return err
+	}
+	l.validUntil = start + uint64(l.ttl-l.drift(l.ttl))
+	return nil
+}
+
+// Validity returns the remaining time the lock is guaranteed to be held by
+// this client, accounting for the time spent acquiring or refreshing it on
+// the nodes and the clock drift. It returns 0 if the lock is not held.
+func (l *RedlockLock) Validity() time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := monotime.Now()
+	if l.validUntil <= now {
+		return 0
+	}
+	return time.Duration(l.validUntil - now)
+}
+
+// Acquire implements the Lock interface, acquiring the lock on all the
+// nodes. It succeeds if the lock is acquired on a quorum of nodes within its
+// validity, otherwise the lock is released on the nodes where it wasn't
+// already held.
+func (l *RedlockLock) Acquire(ttl time.Duration) error {
+	return l.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext implements the Lock interface, with the commands on the
+// nodes bounded by ctx. The lock is released without ctx on failure.
+func (l *RedlockLock) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.ttl = ttl
+	start := monotime.Now()
+	errs := l.fanOut(func(_ int, lock *RedisLock) error {
+		return lock.AcquireContext(ctx, ttl)
+	})
+	err := ctxErr(ctx, l.hold(start, errs, ErrLockHeldByOtherClient))
+	if err != nil {
+		// the lock is left on the nodes where it was already held, possibly
+		// by this client
+		l.fanOut(func(i int, lock *RedisLock) error {
+			if errs[i] == ErrLockHeldByOtherClient {
+				return nil
+			}
+			return lock.Release()
+		})
+	}
+	return err
+}
+
+// WaitAcquire implements the Lock interface
+func (l *RedlockLock) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	return waitAcquire(ctx, func() error { return l.AcquireContext(ctx, ttl) },
+		defaultWaitBackoff, defaultWaitMaxBackoff)
+}
+
+// Refresh implements the Lock interface, refreshing the lock on all the
+// nodes. It fails if the lock cannot be refreshed on a quorum of nodes within
+// its validity, with ErrQuorumLost unless held by another client.
+func (l *RedlockLock) Refresh() error {
+	return l.RefreshContext(context.Background())
+}
+
+// RefreshContext implements the Lock interface, with the commands on the
+// nodes bounded by ctx. It returns ErrQuorumLost if the lock cannot be
+// refreshed on a quorum of nodes, while not held by another client on any.
+func (l *RedlockLock) RefreshContext(ctx context.Context) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.refreshContext(ctx)
+}
+
+func (l *RedlockLock) refreshContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	start := monotime.Now()
+	errs := l.fanOut(func(_ int, lock *RedisLock) error {
+		ttl, err := lock.client.checkTTL(l.ttl)
+		if err != nil {
+			return err
+		}
+		lock.mtx.Lock()
+		defer lock.mtx.Unlock()
+		lock.ttl = ttl
+		return lock.refreshContext(ctx)
+	})
+	err := ctxErr(ctx, l.hold(start, errs, ErrLockNotOwned))
+	if err == ErrNoQuorum {
+		return ErrQuorumLost
+	}
+	return err
+}
+
+// RefreshTTL implements the Lock interface
+func (l *RedlockLock) RefreshTTL(ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	l.ttl = ttl
+	return l.refreshContext(context.Background())
+}
+
+// Release implements the Lock interface, releasing the lock on all the
+// nodes. It succeeds if the lock is released on a quorum of nodes, otherwise
+// it returns ErrLockNotOwned if the lock is held by another client on any
+// node, ErrLockExpired if it expired on any.
+func (l *RedlockLock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// ReleaseContext implements the Lock interface, with the commands on the
+// nodes bounded by ctx
+func (l *RedlockLock) ReleaseContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.validUntil = 0
+	errs := l.fanOut(func(_ int, lock *RedisLock) error {
+		return lock.ReleaseContext(ctx)
+	})
+	err := l.quorumErr(errs, ErrLockNotOwned)
+	if err == ErrNoQuorum {
+		err = l.quorumErr(errs, ErrLockExpired)
+	}
+	return ctxErr(ctx, err)
+}
+
+// ctxErr returns the error of ctx if it's done and err is ErrNoQuorum, i.e.
+// because the nodes failed with the error of ctx
+func ctxErr(ctx context.Context, err error) error {
+	if err == ErrNoQuorum && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// SetData implements the Lock interface
+func (l *RedlockLock) SetData(data string) {
+	for _, lock := range l.locks {
+		lock.SetData(data)
+	}
+}
+
+// Info implements the Lock interface. The lock is reported as acquired if
+// it's held by the same owner on a quorum of nodes, with the shortest TTL
+// among them.
+func (l *RedlockLock) Info() (*LockInfo, error) {
+	infos := make([]*LockInfo, len(l.locks))
+	errs := l.fanOut(func(i int, lock *RedisLock) error {
+		var err error
+		infos[i], err = lock.Info()
+		return err
+	})
+	if err := l.quorumErr(errs, nil); err != nil {
+		return nil, err
+	}
+	owners := make(map[string][]*LockInfo)
+	for _, info := range infos {
+		if info != nil && info.Acquired {
+			owners[info.Owner] = append(owners[info.Owner], info)
+		}
+	}
+	for _, held := range owners {
+		if len(held) < l.client.quorum() {
+			continue
+		}
+		res := *held[0]
+		for _, info := range held {
+			if info.TTL < res.TTL {
+				res.TTL = info.TTL
+			}
+		}
+		return &res, nil
+	}
+	return &LockInfo{Name: l.name, Acquired: false}, nil
+}
+
+// RemainingTTL implements the Lock interface, returning the TTL the lock
+// has on at least a quorum of nodes.
+func (l *RedlockLock) RemainingTTL() (time.Duration, error) {
+	ttls := make(durations, len(l.locks))
+	errs := l.fanOut(func(i int, lock *RedisLock) error {
+		var err error
+		ttls[i], err = lock.RemainingTTL()
+		return err
+	})
+	if err := l.quorumErr(errs, nil); err != nil {
+		return 0, err
+	}
+	sort.Sort(sort.Reverse(ttls))
+	return ttls[l.client.quorum()-1], nil
+}
+
+// durations sorts TTLs, NoExpiry being the longest
+type durations []time.Duration
+
+func (d durations) Len() int      { return len(d) }
+func (d durations) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool {
+	if d[i] == NoExpiry || d[j] == NoExpiry {
+		return d[j] == NoExpiry && d[i] != NoExpiry
+	}
+	return d[i] < d[j]
+}
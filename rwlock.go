@@ -0,0 +1,233 @@
+package glock
+
+import (
+	"context"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// the readers of a RWLock are stored in a sorted set, scored by the time
+// their read lock expires, so that the read locks of crashed readers expire
+// like the write lock.
+const (
+	// serverTimeScriptText sets now to the time of redis in milliseconds, so
+	// that the clocks of the clients don't matter. redis.replicate_commands
+	// allows writing after TIME on redis 3.2 to 4, it's the default since
+	// redis 5.
+	serverTimeScriptText = `
+if redis.replicate_commands then
+	redis.replicate_commands()
+end
+local now = redis.call("time")
+now = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+`
+	rwAcquireScriptText = serverTimeScriptText + `
+local owner = redis.call("get", KEYS[1])
+if owner then
+	return owner
+end
+redis.call("zremrangebyscore", KEYS[2], "-inf", now)
+if ARGV[3] == "w" then
+	if redis.call("zcard", KEYS[2]) > 0 then
+		return 0
+	end
+	redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+redis.call("zadd", KEYS[2], now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("pttl", KEYS[2]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[2], ARGV[2])
+end
+return 1
+`
+	rwRefreshScriptText = serverTimeScriptText + `
+if ARGV[3] == "w" then
+	if redis.call("get", KEYS[1]) == ARGV[1] then
+		redis.call("pexpire", KEYS[1], ARGV[2])
+		return 1
+	end
+	return 0
+end
+local expire = redis.call("zscore", KEYS[2], ARGV[1])
+if not expire or tonumber(expire) <= now then
+	return 0
+end
+redis.call("zadd", KEYS[2], now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("pttl", KEYS[2]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[2], ARGV[2])
+end
+return 1
+`
+	rwReleaseScriptText = serverTimeScriptText + `
+if ARGV[2] == "w" then
+	if redis.call("get", KEYS[1]) == ARGV[1] then
+		redis.call("del", KEYS[1])
+		return 1
+	end
+	return 0
+end
+local expire = redis.call("zscore", KEYS[2], ARGV[1])
+redis.call("zrem", KEYS[2], ARGV[1])
+if not expire or tonumber(expire) <= now then
+	return 0
+end
+return 1
+`
+)
+
+var (
+	rwAcquireScript = redis.NewScript(2, rwAcquireScriptText)
+	rwRefreshScript = redis.NewScript(2, rwRefreshScriptText)
+	rwReleaseScript = redis.NewScript(2, rwReleaseScriptText)
+)
+
+// the modes a RedisRWLock is held in
+const (
+	rwUnlocked = ""
+	rwRead     = "r"
+	rwWrite    = "w"
+)
+
+// RedisRWLock is a reader/writer lock: it can be held either by many readers
+// or by a single writer. Each read lock and the write lock expire after their
+// own TTL, and can be refreshed by their holder. The write lock is stored
+// like a RedisLock with the same name, which excludes writers but not
+// readers. Waiting writers don't prevent new readers from acquiring the lock,
+// so readers overlapping continuously starve the writers.
+// Like the owner of a RedisLock, readers are identified by their client ID:
+// the clients sharing an ID share their read lock.
+type RedisRWLock struct {
+	lock *RedisLock
+	mode string
+}
+
+// NewRWLock creates a reader/writer lock with the given name. Its name
+// shares the namespace of the locks created by NewLock.
+func (c *RedisClient) NewRWLock(name string) *RedisRWLock {
+	return &RedisRWLock{lock: c.newLock(name, "")}
+}
+
+// readersKey returns the key of the sorted set of the readers of the lock
+func (l *RedisRWLock) readersKey() string {
+	return l.lock.companionKey("readers")
+}
+
+// RLock acquires the lock for reading for the specified ttl. It returns
+// immediately, with ErrLockHeldByOtherClient if the lock is held for writing.
+func (l *RedisRWLock) RLock(ttl time.Duration) error {
+	return l.acquire(context.Background(), ttl, rwRead)
+}
+
+// Lock acquires the lock for writing for the specified ttl. It returns
+// immediately, with ErrLockHeldByOtherClient if the lock is held for reading
+// or writing, including by this client.
+func (l *RedisRWLock) Lock(ttl time.Duration) error {
+	return l.acquire(context.Background(), ttl, rwWrite)
+}
+
+// WaitRLock is like RLock, retrying while the lock is held for writing until
+// ctx is done, with the WaitBackoff and WaitMaxBackoff options.
+func (l *RedisRWLock) WaitRLock(ctx context.Context, ttl time.Duration) error {
+	return l.wait(ctx, ttl, rwRead)
+}
+
+// WaitLock is like Lock, retrying while the lock is held until ctx is done,
+// with the WaitBackoff and WaitMaxBackoff options.
+func (l *RedisRWLock) WaitLock(ctx context.Context, ttl time.Duration) error {
+	return l.wait(ctx, ttl, rwWrite)
+}
+
+func (l *RedisRWLock) wait(ctx context.Context, ttl time.Duration, mode string) error {
+	opts := l.lock.client.opts
+	return waitAcquireNotified(ctx, opts.Clock, func() error { return l.acquire(ctx, ttl, mode) },
+		backoffDelay(opts.WaitBackoff, opts.WaitMaxBackoff, opts.WaitJitter), nil)
+}
+
+func (l *RedisRWLock) acquire(ctx context.Context, ttl time.Duration, mode string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.lock.mtx.Lock()
+	defer l.lock.mtx.Unlock()
+	if l.lock.err != nil {
+		return l.lock.err
+	}
+	if l.mode != rwUnlocked {
+		return ErrLockHeldByOtherClient
+	}
+	ttl, err := l.lock.client.checkTTL(ttl)
+	if err != nil {
+		return err
+	}
+	defer l.lock.withContext(ctx)()
+	ms := int(ttl.Nanoseconds() / int64(time.Millisecond))
+	reply, _, err := l.lock.client.run(l.lock.get, func(conn redis.Conn) (interface{}, error) {
+		return rwAcquireScript.Do(conn, l.lock.key(), l.readersKey(), l.lock.client.ID(), ms, mode)
+	})
+	if err != nil {
+		return opError(EventAcquire, "EVALSHA", err)
+	}
+	if res, ok := reply.(int64); !ok || res != 1 {
+		return ErrLockHeldByOtherClient
+	}
+	l.lock.ttl = ttl
+	l.mode = mode
+	return nil
+}
+
+// Refresh extends the read or write lock held by this client for its last
+// TTL. It returns ErrLockNotHeld if the lock was not acquired, and
+// ErrLockNotOwned if the lock expired.
+func (l *RedisRWLock) Refresh() error {
+	l.lock.mtx.Lock()
+	defer l.lock.mtx.Unlock()
+	if l.mode == rwUnlocked {
+		return ErrLockNotHeld
+	}
+	ms := int(l.lock.ttl.Nanoseconds() / int64(time.Millisecond))
+	reply, _, err := l.lock.client.run(l.lock.get, func(conn redis.Conn) (interface{}, error) {
+		return rwRefreshScript.Do(conn, l.lock.key(), l.readersKey(), l.lock.client.ID(), ms, l.mode)
+	})
+	ok, err := redis.Bool(reply, err)
+	if err != nil {
+		return opError(EventRefresh, "EVALSHA", err)
+	}
+	if !ok {
+		l.mode = rwUnlocked
+		return ErrLockNotOwned
+	}
+	return nil
+}
+
+// RUnlock releases the read lock held by this client. It returns
+// ErrLockNotOwned if the lock is not held for reading by this client.
+func (l *RedisRWLock) RUnlock() error {
+	return l.release(rwRead)
+}
+
+// Unlock releases the write lock held by this client. It returns
+// ErrLockNotOwned if the lock is not held for writing by this client.
+func (l *RedisRWLock) Unlock() error {
+	return l.release(rwWrite)
+}
+
+func (l *RedisRWLock) release(mode string) error {
+	l.lock.mtx.Lock()
+	defer l.lock.mtx.Unlock()
+	if l.mode != mode {
+		return ErrLockNotOwned
+	}
+	reply, _, err := l.lock.client.run(l.lock.get, func(conn redis.Conn) (interface{}, error) {
+		return rwReleaseScript.Do(conn, l.lock.key(), l.readersKey(), l.lock.client.ID(), mode)
+	})
+	ok, err := redis.Bool(reply, err)
+	if err != nil {
+		return opError(EventRelease, "EVALSHA", err)
+	}
+	l.mode = rwUnlocked
+	if !ok {
+		return ErrLockNotOwned
+	}
+	return nil
+}
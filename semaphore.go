@@ -0,0 +1,254 @@
+package glock
+
+import (
+	"context"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// the holders of a semaphore are stored in a sorted set, scored by the time
+// their slot expires, see rwlock.go. While the draining key exists, only the
+// current holders can acquire again.
+const (
+	semAcquireScriptText = serverTimeScriptText + `
+redis.call("zremrangebyscore", KEYS[1], "-inf", now)
+if not redis.call("zscore", KEYS[1], ARGV[1]) then
+	if redis.call("exists", KEYS[2]) == 1 then
+		return -1
+	end
+	if redis.call("zcard", KEYS[1]) >= tonumber(ARGV[3]) then
+		return 0
+	end
+end
+redis.call("zadd", KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 1
+`
+	semRefreshScriptText = serverTimeScriptText + `
+local expire = redis.call("zscore", KEYS[1], ARGV[1])
+if not expire or tonumber(expire) <= now then
+	return 0
+end
+redis.call("zadd", KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("pttl", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 1
+`
+	semReleaseScriptText = serverTimeScriptText + `
+local expire = redis.call("zscore", KEYS[1], ARGV[1])
+redis.call("zrem", KEYS[1], ARGV[1])
+if not expire or tonumber(expire) <= now then
+	return -1
+end
+return redis.call("zcount", KEYS[1], "(" .. now, "+inf")
+`
+	semHoldersScriptText = serverTimeScriptText + `
+return redis.call("zcount", KEYS[1], "(" .. now, "+inf")
+`
+)
+
+var (
+	semAcquireScript = redis.NewScript(2, semAcquireScriptText)
+	semRefreshScript = redis.NewScript(1, semRefreshScriptText)
+	semReleaseScript = redis.NewScript(1, semReleaseScriptText)
+	semHoldersScript = redis.NewScript(1, semHoldersScriptText)
+)
+
+// RedisSemaphore is a semaphore limiting to its capacity the number of
+// clients holding it at the same time. Each holder takes a slot for its own
+// TTL, and can refresh it. Like the owner of a RedisLock, holders are
+// identified by their client ID: the clients sharing an ID share their slot.
+type RedisSemaphore struct {
+	lock     *RedisLock
+	capacity int
+}
+
+// NewSemaphore creates a semaphore with the given name and capacity. Its
+// name shares the namespace of the locks created by NewLock. The clients of a
+// semaphore must agree on its capacity.
+func (c *RedisClient) NewSemaphore(name string, capacity int) *RedisSemaphore {
+	s := &RedisSemaphore{lock: c.newLock(name, ""), capacity: capacity}
+	if capacity < 1 && s.lock.err == nil {
+		s.lock.err = ErrInvalidCapacity
+	}
+	return s
+}
+
+// holdersKey returns the key of the sorted set of the holders of the
+// semaphore
+func (s *RedisSemaphore) holdersKey() string {
+	return s.lock.companionKey("holders")
+}
+
+// drainingKey returns the key set while the semaphore is drained
+func (s *RedisSemaphore) drainingKey() string {
+	return s.lock.companionKey("draining")
+}
+
+// Acquire takes a slot of the semaphore for the specified ttl, or refreshes
+// the slot already held by this client. It returns immediately, with
+// ErrSemaphoreFull if all the slots are held by other clients, or
+// ErrDraining if the semaphore is drained and this client holds no slot.
+func (s *RedisSemaphore) Acquire(ttl time.Duration) error {
+	return s.AcquireContext(context.Background(), ttl)
+}
+
+// AcquireContext is like Acquire, with the redis commands bounded by the
+// deadline of ctx and interrupted if ctx is canceled.
+func (s *RedisSemaphore) AcquireContext(ctx context.Context, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.mtx.Lock()
+	defer s.lock.mtx.Unlock()
+	if s.lock.err != nil {
+		return s.lock.err
+	}
+	ttl, err := s.lock.client.checkTTL(ttl)
+	if err != nil {
+		return err
+	}
+	defer s.lock.withContext(ctx)()
+	ms := int(ttl.Nanoseconds() / int64(time.Millisecond))
+	reply, _, err := s.lock.client.run(s.lock.get, func(conn redis.Conn) (interface{}, error) {
+		return semAcquireScript.Do(conn, s.holdersKey(), s.drainingKey(), s.lock.client.ID(), ms, s.capacity)
+	})
+	n, err := redis.Int(reply, err)
+	if err != nil {
+		return opError(EventAcquire, "EVALSHA", err)
+	}
+	switch n {
+	case 0:
+		return ErrSemaphoreFull
+	case -1:
+		return ErrDraining
+	}
+	s.lock.ttl = ttl
+	return nil
+}
+
+// WaitAcquire is like Acquire, retrying while the semaphore is full until
+// ctx is done, with the WaitBackoff and WaitMaxBackoff options.
+func (s *RedisSemaphore) WaitAcquire(ctx context.Context, ttl time.Duration) error {
+	opts := s.lock.client.opts
+	delay := backoffDelay(opts.WaitBackoff, opts.WaitMaxBackoff, opts.WaitJitter)
+	for attempt := 0; ; attempt++ {
+		err := s.AcquireContext(ctx, ttl)
+		if err != ErrSemaphoreFull {
+			return err
+		}
+		timer := opts.Clock.NewTimer(delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// Refresh extends the slot held by this client for its last TTL. It returns
+// ErrLockNotHeld if the semaphore was not acquired, and ErrLockNotOwned if
+// the slot expired.
+func (s *RedisSemaphore) Refresh() error {
+	s.lock.mtx.Lock()
+	defer s.lock.mtx.Unlock()
+	if s.lock.err != nil {
+		return s.lock.err
+	}
+	if s.lock.ttl == 0 {
+		return ErrLockNotHeld
+	}
+	ms := int(s.lock.ttl.Nanoseconds() / int64(time.Millisecond))
+	reply, _, err := s.lock.client.run(s.lock.get, func(conn redis.Conn) (interface{}, error) {
+		return semRefreshScript.Do(conn, s.holdersKey(), s.lock.client.ID(), ms)
+	})
+	ok, err := redis.Bool(reply, err)
+	if err != nil {
+		return opError(EventRefresh, "EVALSHA", err)
+	}
+	if !ok {
+		return ErrLockNotOwned
+	}
+	return nil
+}
+
+// Release frees the slot held by this client. It returns ErrLockNotOwned if
+// this client holds no slot.
+func (s *RedisSemaphore) Release() error {
+	_, err := s.ReleaseLast()
+	return err
+}
+
+// ReleaseLast is like Release, also telling if no other slot is held once
+// this client released its one, i.e. to let the last holder tear down the
+// resource guarded by the semaphore.
+func (s *RedisSemaphore) ReleaseLast() (last bool, err error) {
+	s.lock.mtx.Lock()
+	defer s.lock.mtx.Unlock()
+	if s.lock.err != nil {
+		return false, s.lock.err
+	}
+	reply, _, err := s.lock.client.run(s.lock.get, func(conn redis.Conn) (interface{}, error) {
+		return semReleaseScript.Do(conn, s.holdersKey(), s.lock.client.ID())
+	})
+	n, err := redis.Int(reply, err)
+	if err != nil {
+		return false, opError(EventRelease, "EVALSHA", err)
+	}
+	if n < 0 {
+		return false, ErrLockNotOwned
+	}
+	return n == 0, nil
+}
+
+// Drain stops granting slots of the semaphore to new holders, for all the
+// clients: their acquisitions fail with ErrDraining, while the current
+// holders keep refreshing their slots until they release them. Use
+// HolderCount to wait for the semaphore to be drained, and Resume to grant
+// slots again.
+func (s *RedisSemaphore) Drain() error {
+	if s.lock.err != nil {
+		return s.lock.err
+	}
+	_, _, err := s.lock.client.run(s.lock.get, func(conn redis.Conn) (interface{}, error) {
+		return conn.Do("SET", s.drainingKey(), 1)
+	})
+	if err != nil {
+		return opError("drain", "SET", err)
+	}
+	return nil
+}
+
+// Resume grants slots of a drained semaphore again, see Drain
+func (s *RedisSemaphore) Resume() error {
+	if s.lock.err != nil {
+		return s.lock.err
+	}
+	_, _, err := s.lock.client.run(s.lock.get, func(conn redis.Conn) (interface{}, error) {
+		return conn.Do("DEL", s.drainingKey())
+	})
+	if err != nil {
+		return opError("resume", "DEL", err)
+	}
+	return nil
+}
+
+// HolderCount returns the number of slots of the semaphore currently held
+func (s *RedisSemaphore) HolderCount() (int, error) {
+	if s.lock.err != nil {
+		return 0, s.lock.err
+	}
+	reply, _, err := s.lock.client.run(s.lock.get, func(conn redis.Conn) (interface{}, error) {
+		return semHoldersScript.Do(conn, s.holdersKey())
+	})
+	n, err := redis.Int(reply, err)
+	if err != nil {
+		return 0, opError("info", "EVALSHA", err)
+	}
+	return n, nil
+}
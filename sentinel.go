@@ -0,0 +1,142 @@
+package glock
+
+import (
+	"net"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// sentinel tells if the master is resolved through sentinels, see
+// RedisOptions.SentinelAddresses
+func (c *RedisClient) sentinel() bool {
+	return len(c.opts.SentinelAddresses) > 0
+}
+
+// resolveMaster asks the sentinels, in order, for the address of the master
+func (c *RedisClient) resolveMaster() (string, error) {
+	var options []redis.DialOption
+	if c.opts.CommandTimeout > 0 {
+		options = append(options, redis.DialReadTimeout(c.opts.CommandTimeout),
+			redis.DialWriteTimeout(c.opts.CommandTimeout))
+	}
+	if c.opts.SentinelPassword != "" {
+		options = append(options, redis.DialPassword(c.opts.SentinelPassword))
+	}
+	for _, address := range c.opts.SentinelAddresses {
+		conn, err := c.opts.DialFunc("tcp", address, options...)
+		if err != nil {
+			continue
+		}
+		reply, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", c.opts.SentinelMasterName))
+		conn.Close()
+		if err == nil && len(reply) == 2 {
+			master := net.JoinHostPort(reply[0], reply[1])
+			c.master.Store(master)
+			return master, nil
+		}
+	}
+	return "", ErrMasterNotFound
+}
+
+// dialMaster connects to the master. A master resolved through the sentinels
+// is checked with ROLE, as it may have been demoted in the meantime. Pooled
+// connections resolve the master on each dial, as the pool is not replaced by
+// a failover. On a cluster, it returns a connection to the cluster.
+func (c *RedisClient) dialMaster() (redis.Conn, error) {
+	if c.clustered() {
+		return c.dialCluster(), nil
+	}
+	if !c.sentinel() {
+		return c.dial(c.opts.DialFunc, c.opts.Address)
+	}
+	address := c.MasterAddress()
+	if c.pooled() {
+		var err error
+		if address, err = c.resolveMaster(); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := c.dial(c.opts.DialFunc, address)
+	if err != nil {
+		return nil, err
+	}
+	role, err := redis.Values(conn.Do("ROLE"))
+	if err == nil && len(role) > 0 {
+		if r, _ := redis.String(role[0], nil); r != "master" {
+			err = ErrMasterNotFound
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if c.pooled() {
+		return &demotableConn{Conn: conn}, nil
+	}
+	return conn, nil
+}
+
+// failovers returns the number of writes refused by a demoted master that
+// run retries: once with a single connection, as it's replaced by failover,
+// and for each pooled connection otherwise, as they are discarded one by one.
+func (c *RedisClient) failovers() int {
+	if !c.sentinel() {
+		return 0
+	}
+	return c.opts.PoolSize + 1
+}
+
+// failover follows a failover after a write refused by a demoted master:
+// the client reconnects to the new master, unless it uses a pool, whose
+// connections to the demoted master are discarded when handed back (see
+// demotableConn).
+func (c *RedisClient) failover() error {
+	if c.pooled() {
+		return nil
+	}
+	return c.Reconnect()
+}
+
+// MasterAddress returns the address of the redis master the client is
+// connected to: Address, the master resolved through the sentinels, or the
+// cluster node which listed the masters of the slots
+func (c *RedisClient) MasterAddress() string {
+	address, _ := c.master.Load().(string)
+	return address
+}
+
+// demotableConn is a pooled connection to a master resolved through the
+// sentinels, which breaks once the master refuses a write as demoted, so that
+// the pool discards it
+type demotableConn struct {
+	redis.Conn
+	demoted bool
+}
+
+func (c *demotableConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+	if isReadOnlyError(err) {
+		c.demoted = true
+	}
+	return reply, err
+}
+
+func (c *demotableConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+	if isReadOnlyError(err) {
+		c.demoted = true
+	}
+	return reply, err
+}
+
+func (c *demotableConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+func (c *demotableConn) Err() error {
+	if c.demoted {
+		return ErrReadOnlyReplica
+	}
+	return c.Conn.Err()
+}
@@ -0,0 +1,64 @@
+package glock
+
+import "sync"
+
+// lockSet is the set of the locks held by a client, by key, see
+// RedisOptions.TrackLocks
+type lockSet struct {
+	mtx   sync.Mutex
+	locks map[string]*RedisLock
+}
+
+func newLockSet() *lockSet {
+	return &lockSet{locks: make(map[string]*RedisLock)}
+}
+
+// track adds the acquired lock to the tracked locks, if TrackLocks is set
+func (c *RedisClient) track(l *RedisLock) {
+	if c.tracked == nil {
+		return
+	}
+	c.tracked.mtx.Lock()
+	c.tracked.locks[l.key()] = l
+	c.tracked.mtx.Unlock()
+}
+
+// untrack removes the released lock from the tracked locks, even if it was
+// acquired through another lock object
+func (c *RedisClient) untrack(l *RedisLock) {
+	c.untrackKey(l.key())
+}
+
+// untrackKey removes the lock with the given key from the tracked locks
+func (c *RedisClient) untrackKey(key string) {
+	if c.tracked == nil {
+		return
+	}
+	c.tracked.mtx.Lock()
+	delete(c.tracked.locks, key)
+	c.tracked.mtx.Unlock()
+}
+
+// ReleaseTracked releases the locks acquired through this client and not
+// released yet, if TrackLocks is set. Locks whose validity elapsed (see
+// RedisLock.RemainingValidity) are assumed to be expired, and are dropped
+// without releasing them. It returns a MultiError with the errors of the
+// releases that failed, if any.
+func (c *RedisClient) ReleaseTracked() error {
+	if c.tracked == nil {
+		return nil
+	}
+	c.tracked.mtx.Lock()
+	tracked := c.tracked.locks
+	c.tracked.locks = make(map[string]*RedisLock)
+	c.tracked.mtx.Unlock()
+	// the locks are checked after unlocking the set, as their operations
+	// track them
+	var locks []Lock
+	for _, l := range tracked {
+		if l.RemainingValidity() > 0 {
+			locks = append(locks, l)
+		}
+	}
+	return releaseMulti(locks)
+}
@@ -0,0 +1,107 @@
+package glock
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// LockEvent notifies a change of a lock watched with RedisClient.Watch
+type LockEvent struct {
+	// Lock is the lock name
+	Lock string
+	// Op is EventRelease if the lock was released, EventExpire if it expired
+	Op string
+	// Owner is the owner that released the lock, empty if it expired
+	Owner string
+}
+
+// Watch notifies on the returned channel the releases and expirations of
+// the lock with the given name, until ctx is done. The channel is closed
+// then, or when the subscription fails, i.e. if the connection is lost.
+// Releases are published by Release and ReleaseBatch. Expirations are
+// notified only if redis keyspace notifications are enabled on the server,
+// with notify-keyspace-events including "Kx". Watching uses a dedicated
+// connection.
+func (c *RedisClient) Watch(ctx context.Context, name string) (<-chan LockEvent, error) {
+	l := c.newLock(name, "")
+	if l.err != nil {
+		return nil, l.err
+	}
+	return c.watch(ctx, l)
+}
+
+// keyspaceChannel returns the channel of the keyspace notifications of key
+func (c *RedisClient) keyspaceChannel(key string) string {
+	return "__keyspace@" + strconv.Itoa(c.opts.DB) + "__:" + key
+}
+
+// watch subscribes to the releases and expirations of l. The subscription
+// is confirmed before returning, so that no release happening afterwards is
+// missed.
+func (c *RedisClient) watch(ctx context.Context, l *RedisLock) (<-chan LockEvent, error) {
+	address := c.MasterAddress()
+	if cluster := c.slotTable(); cluster != nil {
+		// keyspace notifications are published by the node serving the key
+		if master := cluster.master(keySlot(l.key())); master != "" {
+			address = master
+		}
+	}
+	conn, err := c.dial(c.opts.DialFunc, address)
+	if err != nil {
+		return nil, opError("watch", "SUBSCRIBE", err)
+	}
+	released, keyspace := l.releasedChannel(), c.keyspaceChannel(l.key())
+	psc := redis.PubSubConn{Conn: conn}
+	if err = psc.Subscribe(released, keyspace); err != nil {
+		conn.Close()
+		return nil, opError("watch", "SUBSCRIBE", err)
+	}
+	for i := 0; i < 2; i++ {
+		switch reply := psc.Receive().(type) {
+		case redis.Subscription:
+		case error:
+			conn.Close()
+			return nil, opError("watch", "SUBSCRIBE", reply)
+		}
+	}
+
+	events := make(chan LockEvent)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+	go func() {
+		defer close(events)
+		defer close(done)
+		for {
+			var event LockEvent
+			switch msg := psc.Receive().(type) {
+			case redis.Message:
+				switch {
+				case msg.Channel == released:
+					event = LockEvent{Lock: l.name, Op: EventRelease, Owner: string(msg.Data)}
+				case msg.Channel == keyspace && string(msg.Data) == "expired":
+					event = LockEvent{Lock: l.name, Op: EventExpire}
+				default:
+					continue
+				}
+			case error:
+				return
+			default:
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
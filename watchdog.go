@@ -0,0 +1,119 @@
+package glock
+
+import (
+	"sync"
+	"time"
+)
+
+// WatchdogOptions configures a Watchdog
+type WatchdogOptions struct {
+	// Interval is how often the namespace is scanned. Defaults to 10s
+	Interval time.Duration
+	// MaxAge is how long a lock can be held by the same owner before OnStuck
+	// is called
+	MaxAge time.Duration
+	// OnStuck is called once for each lock held by the same owner for longer
+	// than MaxAge
+	OnStuck func(info LockInfo, heldFor time.Duration)
+	// OnError is called if a scan fails. Optional
+	OnError func(err error)
+}
+
+// Watchdog periodically scans the locks in a redis namespace and reports the
+// ones held for longer than expected, regardless of their TTL (i.e. locks
+// refreshed forever by a stuck holder).
+// Holds are timed from the first scan observing the current owner, so the
+// reported duration can be shorter than the actual one by up to Interval.
+type Watchdog struct {
+	client *RedisClient
+	opts   WatchdogOptions
+	holds  map[string]*hold
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+type hold struct {
+	owner    string
+	since    time.Time
+	reported bool
+}
+
+// NewWatchdog returns a Watchdog for the namespace of the client.
+// The watchdog uses its own connection, cloned from the client.
+func (c *RedisClient) NewWatchdog(opts WatchdogOptions) *Watchdog {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	return &Watchdog{
+		client: c.Clone().(*RedisClient),
+		opts:   opts,
+		holds:  make(map[string]*hold),
+	}
+}
+
+// Start starts scanning in a background goroutine, until Stop is called
+func (w *Watchdog) Start() error {
+	err := w.client.Reconnect()
+	if err != nil {
+		return err
+	}
+	w.stop = make(chan struct{})
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer w.client.Close()
+		ticker := time.NewTicker(w.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case now := <-ticker.C:
+				err := w.check(now)
+				if err != nil && w.opts.OnError != nil {
+					w.opts.OnError(err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the watchdog and waits for the background goroutine to exit
+func (w *Watchdog) Stop() {
+	if w.stop != nil {
+		close(w.stop)
+		w.wg.Wait()
+		w.stop = nil
+	}
+}
+
+// check scans the namespace once, updating the holds and reporting stuck locks
+func (w *Watchdog) check(now time.Time) error {
+	infos, err := w.client.scanLocks()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		seen[info.Name] = true
+		h, ok := w.holds[info.Name]
+		if !ok || h.owner != info.Owner {
+			h = &hold{owner: info.Owner, since: now}
+			w.holds[info.Name] = h
+		}
+		heldFor := now.Sub(h.since)
+		if !h.reported && heldFor > w.opts.MaxAge {
+			h.reported = true
+			if w.opts.OnStuck != nil {
+				w.opts.OnStuck(*info, heldFor)
+			}
+		}
+	}
+	for name := range w.holds {
+		if !seen[name] {
+			delete(w.holds, name)
+		}
+	}
+	return nil
+}